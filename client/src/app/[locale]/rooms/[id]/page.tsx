@@ -8,6 +8,7 @@ import { Button } from "@/components/Button";
 import { VolumeMeter } from "@/components/VolumeMeter";
 import { SpeakingAnalyzer } from "@/components/SpeakingAnalyzer";
 import { VoteResult, useWebRTC } from "@/hooks/useWebRTC";
+import { uploadMedia } from "@/lib/api";
 
 type LegacyVoteResult = VoteResult & {
   yesCount?: number;
@@ -442,15 +443,9 @@ export default function RoomPage() {
             onChange={(e) => {
               const file = e.target.files?.[0];
               if (!file) return;
-              const reader = new FileReader();
-              reader.onloadend = () => {
-                const result = reader.result as string;
-                if (!result.startsWith("data:")) return;
-                const ext = file.name.split(".").pop()?.toLowerCase();
-                const mediaType = ext === "pdf" ? "pdf" : "image";
-                sendSharedMedia(result, mediaType);
-              };
-              reader.readAsDataURL(file);
+              uploadMedia(file)
+                .then((mediaId) => sendSharedMedia(mediaId))
+                .catch((err) => console.error("❌ Upload failed:", err));
             }}
             className="hidden"
           />
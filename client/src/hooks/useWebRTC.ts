@@ -2,6 +2,15 @@
 
 import { useEffect, useRef, useState, useCallback } from "react";
 import { useRouter } from "next/navigation";
+import {
+  PROTOCOL_VERSION,
+  type ClientInit,
+  type ClientMessage,
+  type PastVote,
+  type ServerMessage,
+  type SharedMedia,
+} from "@/lib/signaling";
+import { mediaUrl } from "@/lib/api";
 
 const SIGNALING_SERVER =
   process.env.NEXT_PUBLIC_SIGNALING_SERVER ||
@@ -16,36 +25,18 @@ export type VoteResult = {
   total: number;
 };
 
-type SignalMessage =
-  | { type: "init"; userId: string }
-  | { type: "join"; roomId: string; isCreator: boolean }
-  | {
-      type: "room-state";
-      users: string[];
-      hostId: string;
-      activeVote?: string | null;
-      currentVotes?: Record<string, "yes" | "no">;
-      sharedMedia?: {
-        type: "shared-media";
-        userId: string;
-        url: string;
-        mediaType: SharedMediaType;
-      };
-      voteHistory?: VoteResult[];
-    }
-  | { type: "offer"; userId: string; offer: RTCSessionDescriptionInit }
-  | { type: "answer"; userId: string; answer: RTCSessionDescriptionInit }
-  | { type: "ice-candidate"; userId: string; candidate: RTCIceCandidateInit }
-  | { type: "leave"; userId: string }
-  | {
-      type: "share-media";
-      url: string;
-      mediaType: SharedMediaType;
-    }
-  | { type: "create-vote"; question: string }
-  | { type: "vote"; userId: string; value: "yes" | "no" }
-  | { type: "end-vote" }
-  | { type: "speaking"; userId: string; isSpeaking: boolean };
+// The server keeps the raw counts; the UI shows yes, no and total.
+const toVoteResult = (v: PastVote): VoteResult => ({
+  question: v.question,
+  yes: v.yesCount,
+  no: v.noCount,
+  total: v.totalVotes,
+});
+
+const toSharedMediaType = (media: SharedMedia): SharedMediaType =>
+  media.mediaType === "pdf" || media.mediaType === "application/pdf"
+    ? "pdf"
+    : "image";
 
 type RemoteStreamEntry = {
   id: string;
@@ -62,9 +53,9 @@ export function useWebRTC(roomId: string) {
     useState<SharedMediaType | null>(null);
   const [localUserId, setLocalUserId] = useState<string | null>(null);
   const [activeVote, setActiveVote] = useState<string | null>(null);
-  const [currentVotes, setCurrentVotes] = useState<
-    Record<string, "yes" | "no">
-  >({});
+  const [currentVotes, setCurrentVotes] = useState<Record<string, string>>(
+    {}
+  );
   const [hostId, setHostId] = useState<string | null>(null);
   const [speakingUsers, setSpeakingUsers] = useState<Set<string>>(new Set());
   const [voteHistory, setVoteHistory] = useState<VoteResult[]>([]);
@@ -87,7 +78,7 @@ export function useWebRTC(roomId: string) {
     setIsHost(localUserId === hostId && isInRoom);
   }, [localUserId, hostId, roomId]);
 
-  const send = useCallback((msg: SignalMessage) => {
+  const send = useCallback((msg: ClientMessage) => {
     const json = JSON.stringify(msg);
     const ws = socketRef.current;
     if (ws?.readyState === WebSocket.OPEN) {
@@ -105,8 +96,13 @@ export function useWebRTC(roomId: string) {
   );
 
   const sendSharedMedia = useCallback(
-    (url: string | null, mediaType: SharedMediaType = "image") => {
-      send({ type: "share-media", url: url || "", mediaType });
+    (mediaId: string | null) => {
+      // Shares go by uploaded media; null clears it for the whole room.
+      if (!mediaId) {
+        send({ type: "clear-media" });
+        return;
+      }
+      send({ type: "share-media", mediaId });
     },
     [send]
   );
@@ -133,7 +129,7 @@ export function useWebRTC(roomId: string) {
   const vote = useCallback(
     (value: "yes" | "no") => {
       if (localUserId && activeVote) {
-        send({ type: "vote", userId: localUserId, value });
+        send({ type: "vote", value });
       }
     },
     [send, localUserId, activeVote]
@@ -346,20 +342,39 @@ export function useWebRTC(roomId: string) {
         });
 
         console.info("✅ WebSocket connected");
-        socket.send(JSON.stringify({ type: "init", userId: storedId }));
+        const init: ClientInit = {
+          type: "init",
+          userId: storedId,
+          connectionId: localStorage.getItem("connectionId") || undefined,
+          authToken: localStorage.getItem("token") || undefined,
+          // Proves this browser already holds the ID, e.g. after a reload.
+          resumeToken: localStorage.getItem("resumeToken") || undefined,
+          protocol: PROTOCOL_VERSION,
+        };
+        socket.send(JSON.stringify(init));
 
         socket.onmessage = async (event) => {
-          const message: SignalMessage = JSON.parse(event.data);
+          const message: ServerMessage = JSON.parse(event.data);
 
           switch (message.type) {
             case "init":
-              localStorage.setItem("clientId", message.userId);
+              // clientId is the stable identity; under the multi policy
+              // the connection ID differs and is kept only for resuming.
+              localStorage.setItem(
+                "clientId",
+                message.identity || message.userId
+              );
+              localStorage.setItem("connectionId", message.userId);
+              if (message.resumeToken) {
+                localStorage.setItem("resumeToken", message.resumeToken);
+              }
               userIdRef.current = message.userId;
               setLocalUserId(message.userId);
               isJoiningRef.current = true;
               const activeRooms = localStorage.getItem("activeRooms");
-              const isCreator =
-                activeRooms && JSON.parse(activeRooms).includes(roomId);
+              const isCreator = !!(
+                activeRooms && JSON.parse(activeRooms).includes(roomId)
+              );
 
               const waitForStream = () =>
                 new Promise<void>((resolve) => {
@@ -397,13 +412,16 @@ export function useWebRTC(roomId: string) {
               setCurrentVotes(message.currentVotes || {});
 
               if (message.sharedMedia) {
-                setSharedMediaUrl(message.sharedMedia.url);
-                setSharedMediaType(message.sharedMedia.mediaType);
+                setSharedMediaUrl(mediaUrl(message.sharedMedia.url));
+                setSharedMediaType(toSharedMediaType(message.sharedMedia));
+              } else {
+                setSharedMediaUrl(null);
+                setSharedMediaType(null);
               }
 
               if (message.voteHistory && userIdRef.current === message.hostId) {
                 const combined = [
-                  ...message.voteHistory,
+                  ...message.voteHistory.map(toVoteResult),
                   ...(JSON.parse(
                     localStorage.getItem(LOCAL_STORAGE_KEY) || "[]"
                   ) as VoteResult[]),
@@ -465,14 +483,14 @@ export function useWebRTC(roomId: string) {
               break;
 
             case "offer": {
-              if (message.userId === userIdRef.current) {
-                console.warn("🛑 Skipping self offer from", message.userId);
+              if (message.from === userIdRef.current) {
+                console.warn("🛑 Skipping self offer from", message.from);
                 return;
               }
 
               const peer =
-                peersRef.current[message.userId] ||
-                createPeer(message.userId, false);
+                peersRef.current[message.from] ||
+                createPeer(message.from, false);
 
               console.log("📥 Got offer. Peer state:", peer.signalingState);
 
@@ -490,7 +508,7 @@ export function useWebRTC(roomId: string) {
 
               send({
                 type: "answer",
-                userId: message.userId,
+                userId: message.from,
                 answer,
               });
 
@@ -498,7 +516,7 @@ export function useWebRTC(roomId: string) {
             }
 
             case "answer":
-              const peer = peersRef.current[message.userId];
+              const peer = peersRef.current[message.from];
               console.log("📥 Got answer. Peer state:", peer?.signalingState);
 
               if (peer && peer.signalingState === "have-local-offer") {
@@ -518,15 +536,15 @@ export function useWebRTC(roomId: string) {
               break;
 
             case "ice-candidate":
-              if (message.userId === userIdRef.current) {
+              if (message.from === userIdRef.current) {
                 console.warn(
                   "🛑 Skipping self ICE candidate from",
-                  message.userId
+                  message.from
                 );
                 return;
               }
 
-              const targetPeer = peersRef.current[message.userId];
+              const targetPeer = peersRef.current[message.from];
               if (
                 targetPeer?.remoteDescription &&
                 targetPeer.remoteDescription.type
@@ -537,7 +555,7 @@ export function useWebRTC(roomId: string) {
               } else {
                 console.warn(
                   "🧊 Skipping ICE candidate, remoteDescription not ready for",
-                  message.userId
+                  message.from
                 );
               }
               break;
@@ -63,6 +63,36 @@ export const register = async (formData: FormData): Promise<AuthResponse> => {
   }
 };
 
+interface MediaResponse {
+  media: { id: string; contentType: string; filename: string };
+  url: string;
+  thumbnailUrl?: string;
+}
+
+// mediaUrl resolves the server's signed media links, which are relative
+// to the API.
+export const mediaUrl = (url: string): string =>
+  url.startsWith("/") ? `${API_BASE_URL || ""}${url}` : url;
+
+// uploadMedia stores an image or PDF and returns its media ID, which is
+// what share-media takes.
+export const uploadMedia = async (file: File): Promise<string> => {
+  const formData = new FormData();
+  formData.append("file", file);
+  try {
+    const res = await api.post<MediaResponse>("/api/media", formData, {
+      headers: {
+        "Content-Type": "multipart/form-data",
+        Authorization: `Bearer ${localStorage.getItem("token") || ""}`,
+      },
+    });
+    return res.data.media.id;
+  } catch (err) {
+    const error = err as AxiosError<ErrorResponse>;
+    throw new Error(error.response?.data?.error || "upload-failed");
+  }
+};
+
 export function isAuthenticated(): boolean {
   if (typeof window !== "undefined") {
     return !!localStorage.getItem("token");
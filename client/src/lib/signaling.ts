@@ -0,0 +1,711 @@
+// Code generated by cmd/protocol-schema; DO NOT EDIT.
+// Run go generate ./services after changing a message.
+
+export const PROTOCOL_VERSION = 2;
+
+export interface ClientAgendaItem {
+  type: "agenda-item";
+  title: string;
+}
+
+export interface ClientAnswer {
+  type: "answer";
+  userId: string;
+  answer: SessionDescription;
+}
+
+export interface ClientAssignBreakout {
+  type: "assign-breakout";
+  userId: string;
+  roomId: string;
+}
+
+export interface ClientBreakoutBroadcast {
+  type: "breakout-broadcast";
+  message: string;
+}
+
+export interface ClientClearMedia {
+  type: "clear-media";
+}
+
+export interface ClientClearReactions {
+  type: "clear-reactions";
+}
+
+export interface ClientCloseBreakouts {
+  type: "close-breakouts";
+}
+
+export interface ClientCreateBreakouts {
+  type: "create-breakouts";
+  count: number;
+  mode?: "random" | "manual";
+  durationSeconds?: number;
+  assignments?: Record<string, number>;
+}
+
+export interface ClientCreateVote {
+  type: "create-vote";
+  question: string;
+}
+
+export interface ClientDemoteSpeaker {
+  type: "demote-speaker";
+  userId: string;
+}
+
+export interface ClientEndVote {
+  type: "end-vote";
+}
+
+export interface ClientHighlight {
+  type: "highlight";
+  text: string;
+}
+
+export interface ClientIceCandidate {
+  type: "ice-candidate";
+  userId: string;
+  candidate: IceCandidate;
+}
+
+export interface ClientInit {
+  type: "init";
+  userId?: string;
+  connectionId?: string;
+  resumeToken?: string;
+  lastSeq?: number;
+  protocol?: number;
+  authToken?: string;
+}
+
+export interface ClientJoin {
+  type: "join";
+  roomId: string;
+  isCreator?: boolean;
+  topology?: "mesh" | "sfu" | "auto";
+}
+
+export interface ClientLeave {
+  type: "leave";
+  userId?: string;
+}
+
+export interface ClientLowerHand {
+  type: "lower-hand";
+}
+
+export interface ClientNextSpeaker {
+  type: "next-speaker";
+}
+
+export interface ClientOffer {
+  type: "offer";
+  userId: string;
+  offer: SessionDescription;
+}
+
+export interface ClientPinMedia {
+  type: "pin-media";
+  itemId: string;
+}
+
+export interface ClientPresentPage {
+  type: "present-page";
+  documentId: string;
+  page: number;
+  zoom?: number | null;
+  follow?: boolean | null;
+}
+
+export interface ClientPromoteSpeaker {
+  type: "promote-speaker";
+  userId: string;
+}
+
+export interface ClientRaiseHand {
+  type: "raise-hand";
+}
+
+export interface ClientReaction {
+  type: "reaction";
+  kind: "emoji" | "thumbs" | "fist-to-five";
+  value: string;
+}
+
+export interface ClientRemoveMedia {
+  type: "remove-media";
+  itemId: string;
+}
+
+export interface ClientSetSpeakingLimits {
+  type: "set-speaking-limits";
+  turnSeconds?: number;
+  totalSeconds?: number;
+}
+
+export interface ClientSetStageMode {
+  type: "set-stage-mode";
+  enabled: boolean;
+  speakers?: string[];
+}
+
+export interface ClientSetTopology {
+  type: "set-topology";
+  topology: "mesh" | "sfu" | "auto";
+}
+
+export interface ClientSfuAnswer {
+  type: "sfu-answer";
+  answer: SessionDescription;
+}
+
+export interface ClientSfuIceCandidate {
+  type: "sfu-ice-candidate";
+  candidate: IceCandidate;
+}
+
+export interface ClientSfuJoin {
+  type: "sfu-join";
+}
+
+export interface ClientSfuLeave {
+  type: "sfu-leave";
+}
+
+export interface ClientShareMedia {
+  type: "share-media";
+  mediaId?: string;
+  url?: string;
+  mediaType?: string;
+  caption?: string;
+}
+
+export interface ClientSpeaking {
+  type: "speaking";
+  userId?: string;
+  isSpeaking: boolean;
+}
+
+export interface ClientStartGoAround {
+  type: "start-go-around";
+  order?: string[];
+  secondsEach: number;
+}
+
+export interface ClientStartLivestream {
+  type: "start-livestream";
+}
+
+export interface ClientStartRecording {
+  type: "start-recording";
+}
+
+export interface ClientStopGoAround {
+  type: "stop-go-around";
+}
+
+export interface ClientStopLivestream {
+  type: "stop-livestream";
+}
+
+export interface ClientStopPresenting {
+  type: "stop-presenting";
+}
+
+export interface ClientStopRecording {
+  type: "stop-recording";
+}
+
+export interface ClientTopologyReady {
+  type: "topology-ready";
+  switchId: string;
+}
+
+export interface ClientUnmuteParticipant {
+  type: "unmute-participant";
+  userId: string;
+}
+
+export interface ClientUnpinMedia {
+  type: "unpin-media";
+  itemId: string;
+}
+
+export interface ClientUpdateRoomInfo {
+  type: "update-room-info";
+  title?: string | null;
+  topic?: string | null;
+  tags?: string[];
+  listed?: boolean | null;
+}
+
+export interface ClientVote {
+  type: "vote";
+  userId?: string;
+  value: "yes" | "no";
+}
+
+export interface ClientYieldFloor {
+  type: "yield-floor";
+}
+
+export type ClientMessage =
+  | ClientAgendaItem
+  | ClientAnswer
+  | ClientAssignBreakout
+  | ClientBreakoutBroadcast
+  | ClientClearMedia
+  | ClientClearReactions
+  | ClientCloseBreakouts
+  | ClientCreateBreakouts
+  | ClientCreateVote
+  | ClientDemoteSpeaker
+  | ClientEndVote
+  | ClientHighlight
+  | ClientIceCandidate
+  | ClientInit
+  | ClientJoin
+  | ClientLeave
+  | ClientLowerHand
+  | ClientNextSpeaker
+  | ClientOffer
+  | ClientPinMedia
+  | ClientPresentPage
+  | ClientPromoteSpeaker
+  | ClientRaiseHand
+  | ClientReaction
+  | ClientRemoveMedia
+  | ClientSetSpeakingLimits
+  | ClientSetStageMode
+  | ClientSetTopology
+  | ClientSfuAnswer
+  | ClientSfuIceCandidate
+  | ClientSfuJoin
+  | ClientSfuLeave
+  | ClientShareMedia
+  | ClientSpeaking
+  | ClientStartGoAround
+  | ClientStartLivestream
+  | ClientStartRecording
+  | ClientStopGoAround
+  | ClientStopLivestream
+  | ClientStopPresenting
+  | ClientStopRecording
+  | ClientTopologyReady
+  | ClientUnmuteParticipant
+  | ClientUnpinMedia
+  | ClientUpdateRoomInfo
+  | ClientVote
+  | ClientYieldFloor;
+
+export interface ServerAgendaItem {
+  type: "agenda-item";
+  title: string;
+}
+
+export interface ServerAnswer {
+  type: "answer";
+  userId: string;
+  from: string;
+  answer: SessionDescription;
+}
+
+export interface ServerBreakoutBroadcast {
+  type: "breakout-broadcast";
+  from: string;
+  message: string;
+}
+
+export interface ServerDashboardSummary {
+  type: "dashboard-summary";
+  rooms: DashboardRoom[];
+  outbound: Outbound;
+}
+
+export interface ServerError {
+  type: "error";
+  code?: "invalid-message" | "duplicate-id";
+  error: string;
+}
+
+export interface ServerFloor {
+  type: "floor";
+  userId: string;
+  endsAt: number;
+  position: number;
+  total: number;
+}
+
+export interface ServerForceMute {
+  type: "force-mute";
+  reason: string;
+  until?: number;
+}
+
+export interface ServerGallery {
+  type: "gallery";
+  roomId: string;
+  items: SharedMedia[];
+}
+
+export interface ServerGalleryItem {
+  type: "gallery-item";
+  item: SharedMedia;
+}
+
+export interface ServerGalleryItemRemoved {
+  type: "gallery-item-removed";
+  itemId: string;
+}
+
+export interface ServerGoAroundEnded {
+  type: "go-around-ended";
+}
+
+export interface ServerIceCandidate {
+  type: "ice-candidate";
+  userId: string;
+  from: string;
+  candidate: IceCandidate;
+}
+
+export interface ServerInit {
+  type: "init";
+  userId: string;
+  identity?: string;
+  resumeToken: string;
+  duplicatePolicy?: "reject" | "takeover" | "multi";
+  resumed?: boolean;
+  protocol: number;
+}
+
+export interface ServerLeave {
+  type: "leave";
+  userId: string;
+}
+
+export interface ServerMoveToRoom {
+  type: "move-to-room";
+  roomId: string;
+  parentRoomId: string;
+}
+
+export interface ServerOffer {
+  type: "offer";
+  userId: string;
+  from: string;
+  offer: SessionDescription;
+}
+
+export interface ServerPeerReconnecting {
+  type: "peer-reconnecting";
+  userId: string;
+}
+
+export interface ServerPeerResumed {
+  type: "peer-resumed";
+  userId: string;
+}
+
+export interface ServerPresentPage {
+  type: "present-page";
+  documentId: string;
+  presenterId: string;
+  page: number;
+  zoom: number;
+  follow: boolean;
+  updatedAt: number;
+}
+
+export interface ServerReaction {
+  type: "reaction";
+  userId: string;
+  kind: "emoji" | "thumbs" | "fist-to-five";
+  value: string;
+}
+
+export interface ServerResumed {
+  type: "resumed";
+  roomId: string;
+  events: RoomEvent[];
+  complete: boolean;
+}
+
+export interface ServerRoomState {
+  type: "room-state";
+  seq: number;
+  users: string[];
+  identities: Record<string, string>;
+  latency: Record<string, number>;
+  hostId: string;
+  activeVote: string;
+  currentVotes: Record<string, string>;
+  roomInfo: RoomInfo;
+  topology: string;
+  topologyInfo: Topology;
+  recording: Recording;
+  livestream: Livestream;
+  stage: Stage;
+  floor: Floor;
+  sharedMedia?: SharedMedia;
+  presentation?: Presentation;
+  parentRoomId?: string;
+  breakoutEndsAt?: number;
+  hostToken?: string;
+  voteHistory?: PastVote[];
+  reactions?: Reactions;
+  participation?: Participation;
+  breakouts?: Breakout[];
+}
+
+export interface ServerSessionReplaced {
+  type: "session-replaced";
+}
+
+export interface ServerSfuIceCandidate {
+  type: "sfu-ice-candidate";
+  candidate: IceCandidate;
+}
+
+export interface ServerSfuOffer {
+  type: "sfu-offer";
+  offer: SessionDescription;
+}
+
+export interface ServerSpeaking {
+  type: "speaking";
+  userId: string;
+  isSpeaking: boolean;
+}
+
+export interface ServerSpeakingWarning {
+  type: "speaking-warning";
+  limit: "turn" | "total";
+  remainingMs: number;
+}
+
+export interface ServerStageRole {
+  type: "stage-role";
+  role: "speaker" | "listener";
+}
+
+export interface ServerTopologyAbort {
+  type: "topology-abort";
+  switchId: string;
+}
+
+export interface ServerTopologyCommit {
+  type: "topology-commit";
+  switchId: string;
+  topology: string;
+}
+
+export interface ServerTopologySwitch {
+  type: "topology-switch";
+  switchId: string;
+  from: string;
+  to: string;
+}
+
+export interface ServerUnmute {
+  type: "unmute";
+}
+
+export type ServerMessage =
+  | ServerAgendaItem
+  | ServerAnswer
+  | ServerBreakoutBroadcast
+  | ServerDashboardSummary
+  | ServerError
+  | ServerFloor
+  | ServerForceMute
+  | ServerGallery
+  | ServerGalleryItem
+  | ServerGalleryItemRemoved
+  | ServerGoAroundEnded
+  | ServerIceCandidate
+  | ServerInit
+  | ServerLeave
+  | ServerMoveToRoom
+  | ServerOffer
+  | ServerPeerReconnecting
+  | ServerPeerResumed
+  | ServerPresentPage
+  | ServerReaction
+  | ServerResumed
+  | ServerRoomState
+  | ServerSessionReplaced
+  | ServerSfuIceCandidate
+  | ServerSfuOffer
+  | ServerSpeaking
+  | ServerSpeakingWarning
+  | ServerStageRole
+  | ServerTopologyAbort
+  | ServerTopologyCommit
+  | ServerTopologySwitch
+  | ServerUnmute;
+
+export interface Breakout {
+  roomId: string;
+  users: string[];
+}
+
+export interface DashboardRoom {
+  roomId: string;
+  hostId: string;
+  participantCount: number;
+  topology: string;
+  maxQueueDepth: number;
+  instance: string;
+  parentRoomId?: string;
+  speakerCount?: number | null;
+  listenerCount?: number | null;
+  activeVote?: DashboardVote;
+}
+
+export interface DashboardVote {
+  question: string;
+  yes: number;
+  no: number;
+}
+
+export interface FistToFive {
+  responses: number;
+  distribution: number[];
+  average?: number | null;
+}
+
+export interface Floor {
+  turnLimitSeconds: number;
+  totalLimitSeconds: number;
+  muted: Record<string, string>;
+  goAround?: GoAround;
+}
+
+export interface GoAround {
+  order: string[];
+  holder: string;
+  endsAt: number;
+  seconds: number;
+}
+
+export interface IceCandidate {
+  candidate?: string | null;
+  sdpMid?: string | null;
+  sdpMLineIndex?: number | null;
+  usernameFragment?: string | null;
+}
+
+export interface Livestream {
+  active: boolean;
+  url?: string;
+  startedAt?: number;
+}
+
+export interface Migration {
+  switchId: string;
+  to: string;
+}
+
+export interface Outbound {
+  connections: number;
+  queued: number;
+  maxDepth: number;
+  sent: number;
+  dropped: number;
+  coalesced: number;
+  disconnects: number;
+}
+
+export interface Participation {
+  totalSpeakingMs: number;
+  participants: ParticipationEntry[];
+}
+
+export interface ParticipationEntry {
+  userId: string;
+  speakingMs: number;
+  share: number;
+  turns: number;
+  votesCast: number;
+}
+
+export interface PastVote {
+  question: string;
+  totalVotes: number;
+  yesCount: number;
+  noCount: number;
+}
+
+export interface Presentation {
+  documentId: string;
+  presenterId: string;
+  page: number;
+  zoom: number;
+  follow: boolean;
+  updatedAt: number;
+}
+
+export interface Reactions {
+  emoji: Record<string, number>;
+  thumbs: Record<string, number>;
+  fistToFive: FistToFive;
+}
+
+export interface Recording {
+  active: boolean;
+  id?: string;
+  startedAt?: number;
+}
+
+export interface RoomEvent {
+  roomId: string;
+  seq: number;
+  type: string;
+  actor?: string;
+  data: unknown;
+  at: string;
+}
+
+export interface RoomInfo {
+  title: string;
+  topic: string;
+  tags: string[];
+  listed: boolean;
+}
+
+export interface SessionDescription {
+  type: "offer" | "answer" | "pranswer" | "rollback";
+  sdp?: string;
+}
+
+export interface SharedMedia {
+  type: "shared-media";
+  itemId: string;
+  userId: string;
+  url: string;
+  mediaType: string;
+  caption: string;
+  pinned: boolean;
+  sharedAt: number;
+  mediaId?: string;
+  filename?: string;
+  thumbnailUrl?: string;
+}
+
+export interface Stage {
+  enabled: boolean;
+  raisedHands: string[];
+  speakers?: string[];
+  speakerCount?: number | null;
+  listenerCount?: number | null;
+}
+
+export interface Topology {
+  mode: "manual" | "auto";
+  switchUp: number;
+  switchDown: number;
+  migration?: Migration;
+}
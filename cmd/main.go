@@ -56,12 +56,27 @@ func main() {
 		log.Fatal("❌ DB init failed:", err)
 	}
 
-	app := fiber.New()
+	if err := services.StartCluster(); err != nil {
+		log.Fatal("❌ Broker init failed:", err)
+	}
+
+	if os.Getenv("TURN_ENABLED") == "true" {
+		turnServer, err := services.StartTURNServer()
+		if err != nil {
+			log.Fatal("❌ TURN init failed:", err)
+		}
+		defer turnServer.Close()
+	}
+
+	// Leave headroom over the media limit for multipart framing.
+	app := fiber.New(fiber.Config{
+		BodyLimit: int(services.MediaMaxBytes()) + 1<<20,
+	})
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     frontendURL,
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Content-Type, Authorization",
+		AllowHeaders:     "Content-Type, Authorization, X-Room-Token",
 		AllowCredentials: true,
 	}))
 
@@ -0,0 +1,33 @@
+// Command protocol-schema writes the signaling protocol's JSON Schema to
+// the file named by its first argument, or to stdout, and its TypeScript
+// types to the file named by its second.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/nbursa/agoranet/services"
+)
+
+func main() {
+	data, err := json.MarshalIndent(services.ProtocolSchema(), "", "  ")
+	if err != nil {
+		log.Fatal("❌ Failed to encode schema:", err)
+	}
+	data = append(data, '\n')
+
+	if len(os.Args) < 2 {
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(os.Args[1], data, 0o644); err != nil {
+		log.Fatal("❌ Failed to write schema:", err)
+	}
+	if len(os.Args) > 2 {
+		if err := os.WriteFile(os.Args[2], []byte(services.ProtocolTypeScript()), 0o644); err != nil {
+			log.Fatal("❌ Failed to write TypeScript types:", err)
+		}
+	}
+}
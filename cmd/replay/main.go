@@ -0,0 +1,79 @@
+// Command replay rebuilds a room's state from its event log, as it was
+// after a given event or at a given time.
+//
+//	go run ./cmd/replay -room <roomId> [-seq N | -at 2026-01-02T15:04:05Z] [-events]
+//
+// It reads the same DB_TYPE and DB_PATH settings as the server.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/services"
+)
+
+func main() {
+	roomID := flag.String("room", "", "room ID to replay")
+	seq := flag.Int64("seq", 0, "stop after this event (default: the last)")
+	at := flag.String("at", "", "stop at this RFC 3339 time")
+	events := flag.Bool("events", false, "list the events applied before the state")
+	flag.Parse()
+
+	if *roomID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var until time.Time
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("❌ Invalid -at: %v", err)
+		}
+		until = t
+	}
+
+	_ = godotenv.Load()
+	if err := config.InitDatabase(); err != nil {
+		log.Fatal("❌ DB init failed:", err)
+	}
+
+	if *events {
+		var after int64
+		for {
+			page := services.RoomEvents(*roomID, after, 0)
+			for _, ev := range page {
+				if (*seq > 0 && ev.Seq > *seq) || (!until.IsZero() && ev.At.After(until)) {
+					page = nil
+					break
+				}
+				fmt.Println(services.DescribeEvent(ev))
+				after = ev.Seq
+			}
+			if len(page) == 0 {
+				break
+			}
+		}
+		fmt.Println()
+	}
+
+	state, last, err := services.ReplayRoom(*roomID, *seq, until)
+	if err != nil {
+		log.Fatalf("❌ Replay failed at event %d: %v", last, err)
+	}
+
+	out, _ := json.MarshalIndent(map[string]interface{}{
+		"roomId": *roomID,
+		"seq":    last,
+		"state":  state,
+	}, "", "  ")
+	fmt.Println(string(out))
+}
@@ -27,6 +27,6 @@ func InitDatabase() error {
 	}
 
 	fmt.Println("✅ Database connected!")
-	DB.AutoMigrate(&models.User{}, &models.Vote{})
+	DB.AutoMigrate(&models.User{}, &models.Vote{}, &models.Room{}, &models.Recording{}, &models.ScheduledRoom{}, &models.ScheduledRoomAttendee{}, &models.Media{}, &models.GalleryItem{}, &models.ParticipationReport{}, &models.ParticipationEntry{}, &models.MeetingMinutes{}, &models.RoomEvent{})
 	return nil
 }
@@ -1,6 +1,11 @@
 package controllers
 
 import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+
 	"github.com/nbursa/agoranet/config"
 	"github.com/nbursa/agoranet/models"
 	"github.com/nbursa/agoranet/services"
@@ -129,4 +134,43 @@ func GetCurrentUser(c *fiber.Ctx) error {
 	})
 }
 
+// UploadAvatar replaces the caller's avatar. The picture is re-encoded
+// server-side so EXIF data such as GPS position is never published.
+func UploadAvatar(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing avatar"})
+	}
+	if header.Size > services.MediaMaxBytes() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": services.ErrMediaTooLarge.Error()})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read file"})
+	}
+	defer file.Close()
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read file"})
+	}
+
+	url, err := services.StoreAvatar(raw, username)
+	switch {
+	case errors.Is(err, services.ErrMediaType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "Avatar must be an image"})
+	case errors.Is(err, services.ErrMediaTooLarge), errors.Is(err, services.ErrImageTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		log.Printf("❌ Avatar upload failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store avatar"})
+	}
 
+	return c.JSON(fiber.Map{
+		"avatar":    url,
+		"thumbnail": strings.TrimSuffix(url, ".png") + "_thumb.png",
+	})
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRoomDirectory lists live rooms whose hosts opted into the public
+// directory. Supports ?q= free-text search and ?tag= filtering.
+func GetRoomDirectory(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"rooms": services.ListedRooms(c.Query("q"), c.Query("tag")),
+	})
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRoomEvents returns a room's log after the `since` sequence number,
+// oldest first, unlocked by the host's room token. Clients page by passing
+// the last seq they received.
+func GetRoomEvents(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	since, err := strconv.ParseInt(c.Query("since", "0"), 10, 64)
+	if err != nil || since < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid since"})
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil || limit < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit"})
+	}
+
+	return c.JSON(fiber.Map{"events": services.SessionEvents(grant, since, limit)})
+}
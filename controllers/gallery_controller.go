@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRoomGallery returns everything shared in a room session, for review
+// after the meeting. Like recordings, it is unlocked by the host's room
+// token.
+func GetRoomGallery(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	return c.JSON(services.RoomGallery(grant))
+}
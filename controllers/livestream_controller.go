@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var livestreamSegment = regexp.MustCompile(`^segment[0-9]+\.ts$`)
+
+// ServeLivestreamFile serves a public HLS playlist or segment. Only the
+// names ffmpeg produces are accepted, never arbitrary paths.
+func ServeLivestreamFile(c *fiber.Ctx) error {
+	id := c.Params("id")
+	name := c.Params("file")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Stream not found"})
+	}
+
+	switch {
+	case name == "index.m3u8":
+		c.Set(fiber.HeaderContentType, "application/vnd.apple.mpegurl")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+	case livestreamSegment.MatchString(name):
+		c.Set(fiber.HeaderContentType, "video/mp2t")
+	default:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+	}
+
+	// Read directly: SendFile caches handles and would serve a stale playlist.
+	data, err := os.ReadFile(filepath.Join(services.LivestreamDir(id), name))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+	}
+	return c.Send(data)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UploadMedia stores an image or PDF for `share-media` and returns its
+// content ID with a short-lived signed URL.
+func UploadMedia(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing file"})
+	}
+	if header.Size > services.MediaMaxBytes() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": services.ErrMediaTooLarge.Error()})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Could not read file"})
+	}
+	defer file.Close()
+
+	media, err := services.StoreMedia(file, header.Filename, username)
+	switch {
+	case errors.Is(err, services.ErrMediaTooLarge), errors.Is(err, services.ErrImageTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrMediaType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		log.Printf("❌ Media upload failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store file"})
+	}
+
+	response := fiber.Map{
+		"media": media,
+		"url":   services.MediaURL(media.ID),
+	}
+	if media.ThumbnailType != "" {
+		response["thumbnailUrl"] = services.MediaThumbnailURL(media.ID)
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// DownloadMedia serves an upload to holders of a valid signed URL.
+func DownloadMedia(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !services.VerifyMediaURL(id, c.Query("expires"), c.Query("sig")) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid or expired link"})
+	}
+
+	var media models.Media
+	if result := config.DB.Where("id = ?", id).First(&media); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Media not found"})
+	}
+
+	path, contentType := services.MediaPath(media.ID), media.ContentType
+	if c.Query("variant") == "thumb" {
+		if media.ThumbnailType == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No thumbnail for this media"})
+		}
+		path, contentType = services.MediaThumbnailPath(media.ID), media.ThumbnailType
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; sandbox")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return c.SendFile(path)
+}
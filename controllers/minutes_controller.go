@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListMinutes returns the sessions of a room that have minutes, unlocked by
+// the host's room token.
+func ListMinutes(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	return c.JSON(services.RoomMinutes(grant))
+}
+
+// GetMinutes returns one session's minutes as JSON, or as Markdown when the
+// ID ends in ".md".
+func GetMinutes(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	id, markdown := strings.CutSuffix(c.Params("id"), ".md")
+	minutes, ok := services.LoadMinutes(grant, id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Minutes not found"})
+	}
+
+	if markdown {
+		c.Set(fiber.HeaderContentType, "text/markdown; charset=utf-8")
+		return c.SendString(services.MinutesMarkdown(minutes))
+	}
+	return c.JSON(minutes)
+}
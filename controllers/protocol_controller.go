@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetProtocolSchema serves the JSON Schema of the signaling messages a
+// client may send.
+func GetProtocolSchema(c *fiber.Ctx) error {
+	return c.JSON(services.ProtocolSchema())
+}
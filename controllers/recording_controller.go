@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"path/filepath"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// roomToken reads the host's room access token from the X-Room-Token
+// header, or from ?token= so plain download links work.
+func roomToken(c *fiber.Ctx) string {
+	if token := c.Get("X-Room-Token"); token != "" {
+		return token
+	}
+	return c.Query("token")
+}
+
+func ListRoomRecordings(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	var all []models.Recording
+	config.DB.Where("room_id = ?", roomID).Order("started_at desc").Find(&all)
+
+	recordings := []models.Recording{}
+	for _, recording := range all {
+		if grant.Covers(recording.StartedAt) {
+			recordings = append(recordings, recording)
+		}
+	}
+	return c.JSON(recordings)
+}
+
+func GetRecording(c *fiber.Ctx) error {
+	recording, ferr := accessibleRecording(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	return c.JSON(fiber.Map{
+		"recording": recording,
+		"files":     services.RecordingFiles(recording.ID),
+	})
+}
+
+func DownloadRecordingFile(c *fiber.Ctx) error {
+	recording, ferr := accessibleRecording(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	// Only serve names listed for the recording, never arbitrary paths.
+	name := c.Params("name")
+	for _, f := range services.RecordingFiles(recording.ID) {
+		if f.File == name {
+			c.Set(fiber.HeaderContentType, "audio/ogg")
+			return c.Download(filepath.Join(services.RecordingDir(recording.ID), name), name)
+		}
+	}
+
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+}
+
+func accessibleRecording(c *fiber.Ctx) (models.Recording, *fiber.Error) {
+	var recording models.Recording
+	if result := config.DB.Where("id = ?", c.Params("id")).First(&recording); result.Error != nil {
+		return recording, fiber.NewError(fiber.StatusNotFound, "Recording not found")
+	}
+	if grant, ok := services.VerifyRoomAccessToken(recording.RoomID, roomToken(c)); !ok || !grant.Covers(recording.StartedAt) {
+		return recording, fiber.NewError(fiber.StatusForbidden, "Invalid room token")
+	}
+	return recording, nil
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetParticipationReports returns the end-of-meeting participation reports
+// of a room, unlocked by the host's room token.
+func GetParticipationReports(c *fiber.Ctx) error {
+	roomID := c.Params("roomId")
+	grant, ok := services.VerifyRoomAccessToken(roomID, roomToken(c))
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid room token"})
+	}
+
+	return c.JSON(services.ParticipationReports(grant))
+}
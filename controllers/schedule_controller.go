@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type scheduleInput struct {
+	RoomID          string `json:"roomId"`
+	Title           string `json:"title"`
+	Description     string `json:"description"`
+	StartTime       string `json:"startTime"`
+	DurationMinutes int    `json:"durationMinutes"`
+}
+
+func (in scheduleInput) apply(s *models.ScheduledRoom) error {
+	if strings.TrimSpace(in.Title) == "" {
+		return errors.New("Missing title")
+	}
+
+	start, err := time.Parse(time.RFC3339, in.StartTime)
+	if err != nil {
+		return errors.New("startTime must be an RFC 3339 timestamp")
+	}
+
+	duration := in.DurationMinutes
+	if duration == 0 {
+		duration = 60
+	}
+	if duration < 1 || duration > 24*60 {
+		return errors.New("durationMinutes must be between 1 and 1440")
+	}
+
+	s.Title = strings.TrimSpace(in.Title)
+	s.Description = in.Description
+	s.StartTime = start.UTC()
+	s.DurationMinutes = duration
+	return nil
+}
+
+func CreateScheduledRoom(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	var input scheduleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	// The scheduler hosts the room, so a roomId may only name one of their
+	// own scheduled rooms; new rooms get a fresh ID.
+	if input.RoomID != "" && !services.SchedulesRoom(username, input.RoomID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "roomId must be a room you have scheduled before"})
+	}
+
+	schedule := models.ScheduledRoom{
+		ID:        uuid.New().String(),
+		RoomID:    input.RoomID,
+		CreatedBy: username,
+	}
+	if schedule.RoomID == "" {
+		schedule.RoomID = strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+	}
+
+	if err := input.apply(&schedule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := services.CheckSchedule(schedule); err != nil {
+		return scheduleConflict(c, err)
+	}
+
+	if result := config.DB.Create(&schedule); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create schedule"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(schedule)
+}
+
+// ListScheduledRooms returns the sessions the caller organizes or attends.
+func ListScheduledRooms(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	var schedules []models.ScheduledRoom
+	if err := userSchedules(username).Find(&schedules).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load schedules"})
+	}
+
+	return c.JSON(schedules)
+}
+
+func GetScheduledRoom(c *fiber.Ctx) error {
+	var schedule models.ScheduledRoom
+	if result := config.DB.Where("id = ?", c.Params("id")).First(&schedule); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	var attendees []string
+	config.DB.Model(&models.ScheduledRoomAttendee{}).
+		Where("scheduled_room_id = ?", schedule.ID).
+		Pluck("username", &attendees)
+
+	return c.JSON(fiber.Map{"schedule": schedule, "attendees": attendees})
+}
+
+func UpdateScheduledRoom(c *fiber.Ctx) error {
+	schedule, ferr := ownedSchedule(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var input scheduleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	if err := input.apply(&schedule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := services.CheckSchedule(schedule); err != nil {
+		return scheduleConflict(c, err)
+	}
+
+	if result := config.DB.Save(&schedule); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update schedule"})
+	}
+
+	return c.JSON(schedule)
+}
+
+func DeleteScheduledRoom(c *fiber.Ctx) error {
+	schedule, ferr := ownedSchedule(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	config.DB.Where("scheduled_room_id = ?", schedule.ID).Delete(&models.ScheduledRoomAttendee{})
+	if result := config.DB.Delete(&schedule); result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete schedule"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Schedule deleted"})
+}
+
+func AttendScheduledRoom(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	var schedule models.ScheduledRoom
+	if result := config.DB.Where("id = ?", c.Params("id")).First(&schedule); result.Error != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Schedule not found"})
+	}
+
+	attendee := models.ScheduledRoomAttendee{ScheduledRoomID: schedule.ID, Username: username}
+	config.DB.Where(attendee).FirstOrCreate(&attendee)
+
+	return c.JSON(fiber.Map{"message": "Added to attendees"})
+}
+
+func LeaveScheduledRoom(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	config.DB.Where("scheduled_room_id = ? AND username = ?", c.Params("id"), username).
+		Delete(&models.ScheduledRoomAttendee{})
+
+	return c.JSON(fiber.Map{"message": "Removed from attendees"})
+}
+
+// GetCalendarFeedURL hands out the tokenized per-user feed path to subscribe to.
+func GetCalendarFeedURL(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	token, err := services.CalendarFeedToken(username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate feed token"})
+	}
+
+	return c.JSON(fiber.Map{
+		"url": c.BaseURL() + "/calendar/users/" + username + ".ics?token=" + token,
+	})
+}
+
+func UserCalendarFeed(c *fiber.Ctx) error {
+	username := c.Params("username")
+	if !services.VerifyCalendarFeedToken(username, c.Query("token")) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid feed token"})
+	}
+
+	var schedules []models.ScheduledRoom
+	userSchedules(username).Find(&schedules)
+
+	return sendICS(c, "AgoraNet – "+username, schedules)
+}
+
+func RoomCalendarFeed(c *fiber.Ctx) error {
+	var schedules []models.ScheduledRoom
+	config.DB.Where("room_id = ?", c.Params("roomId")).Order("start_time").Find(&schedules)
+
+	return sendICS(c, "AgoraNet – "+c.Params("roomId"), schedules)
+}
+
+func userSchedules(username string) *gorm.DB {
+	attending := config.DB.Model(&models.ScheduledRoomAttendee{}).
+		Select("scheduled_room_id").
+		Where("username = ?", username)
+
+	return config.DB.Where("created_by = ? OR id IN (?)", username, attending).Order("start_time")
+}
+
+func ownedSchedule(c *fiber.Ctx) (models.ScheduledRoom, *fiber.Error) {
+	username, _ := c.Locals("username").(string)
+
+	var schedule models.ScheduledRoom
+	if result := config.DB.Where("id = ?", c.Params("id")).First(&schedule); result.Error != nil {
+		return schedule, fiber.NewError(fiber.StatusNotFound, "Schedule not found")
+	}
+	if schedule.CreatedBy != username {
+		return schedule, fiber.NewError(fiber.StatusForbidden, "Only the organizer can change this schedule")
+	}
+	return schedule, nil
+}
+
+func scheduleConflict(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrRoomScheduledByOther) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+}
+
+func sendICS(c *fiber.Ctx, name string, schedules []models.ScheduledRoom) error {
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="agoranet.ics"`)
+	return c.SendString(services.BuildICS(name, schedules))
+}
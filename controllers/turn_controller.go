@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"github.com/nbursa/agoranet/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetTURNCredentials issues short-lived TURN credentials for the caller to
+// plug into RTCPeerConnection's iceServers.
+func GetTURNCredentials(c *fiber.Ctx) error {
+	username, _ := c.Locals("username").(string)
+
+	creds, err := services.IssueTURNCredentials(username)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "TURN is not configured"})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(creds)
+}
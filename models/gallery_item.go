@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// GalleryItem is one piece of media shared in a room, kept for review after
+// the meeting. Uploaded files reference Media by MediaID; anything else is a
+// plain URL.
+type GalleryItem struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	RoomID    string    `gorm:"index;not null" json:"roomId"`
+	SharedBy  string    `json:"sharedBy"`
+	MediaID   string    `json:"mediaId,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	MediaType string    `json:"mediaType"`
+	Filename  string    `json:"filename,omitempty"`
+	Caption   string    `json:"caption"`
+	Pinned    bool      `json:"pinned"`
+	SharedAt  time.Time `json:"sharedAt"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Media is an uploaded file. ID is the SHA-256 of the stored content, so the
+// same file uploaded twice is stored once. Images are re-encoded without
+// metadata and get a thumbnail.
+type Media struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	UploadedBy    string    `gorm:"index" json:"uploadedBy"`
+	ContentType   string    `json:"contentType"`
+	Size          int64     `json:"size"`
+	Filename      string    `json:"filename"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	ThumbnailType string    `json:"thumbnailType,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
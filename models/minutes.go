@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// MeetingMinutes stores the generated minutes of one room session. The
+// structured document is kept as JSON in Document.
+type MeetingMinutes struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	RoomID    string    `gorm:"index;not null" json:"roomId"`
+	Title     string    `json:"title"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	Document  string    `json:"-"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ParticipationReport summarizes one meeting of a room, from the first
+// join until the room emptied.
+type ParticipationReport struct {
+	ID              string               `gorm:"primaryKey" json:"id"`
+	RoomID          string               `gorm:"index;not null" json:"roomId"`
+	StartedAt       time.Time            `json:"startedAt"`
+	EndedAt         time.Time            `json:"endedAt"`
+	TotalSpeakingMs int64                `json:"totalSpeakingMs"`
+	Participants    []ParticipationEntry `gorm:"foreignKey:ReportID" json:"participants"`
+}
+
+type ParticipationEntry struct {
+	ID         uint    `gorm:"primaryKey" json:"-"`
+	ReportID   string  `gorm:"index;not null" json:"-"`
+	UserID     string  `json:"userId"`
+	SpeakingMs int64   `json:"speakingMs"`
+	Share      float64 `json:"share"`
+	Turns      int     `json:"turns"`
+	VotesCast  int     `json:"votesCast"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	RecordingActive     = "recording"
+	RecordingProcessing = "processing"
+	RecordingReady      = "ready"
+	RecordingFailed     = "failed"
+)
+
+type Recording struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	RoomID    string     `gorm:"index;not null" json:"roomId"`
+	StartedBy string     `json:"startedBy"`
+	Status    string     `json:"status"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+}
@@ -1,7 +1,30 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
+// Room holds the persisted metadata of a signaling room. HostID is the
+// signaling client ID of the host, not a User row.
 type Room struct {
-	ID       string `gorm:"primaryKey"`
-	HostID   string
-	Host     User `gorm:"foreignKey:HostID"`
+	ID        string    `gorm:"primaryKey" json:"roomId"`
+	HostID    string    `json:"hostId"`
+	Title     string    `json:"title"`
+	Topic     string    `json:"topic"`
+	Tags      string    `json:"-"`
+	Listed    bool      `gorm:"index" json:"listed"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TagList splits the comma-separated Tags column.
+func (r Room) TagList() []string {
+	tags := []string{}
+	for _, t := range strings.Split(r.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
 }
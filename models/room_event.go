@@ -0,0 +1,18 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RoomEvent is one entry in a room's append-only log. Seq increases by one
+// per event within a room; Data is the event's JSON payload.
+type RoomEvent struct {
+	ID     uint            `gorm:"primaryKey" json:"-"`
+	RoomID string          `gorm:"uniqueIndex:idx_room_event_seq;not null" json:"roomId"`
+	Seq    int64           `gorm:"uniqueIndex:idx_room_event_seq;not null" json:"seq"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor,omitempty"`
+	Data   json.RawMessage `json:"data"`
+	At     time.Time       `json:"at"`
+}
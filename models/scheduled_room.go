@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type ScheduledRoom struct {
+	ID              string    `gorm:"primaryKey" json:"id"`
+	RoomID          string    `gorm:"index;not null" json:"roomId"`
+	Title           string    `gorm:"not null" json:"title"`
+	Description     string    `json:"description"`
+	StartTime       time.Time `gorm:"index;not null" json:"startTime"`
+	DurationMinutes int       `json:"durationMinutes"`
+	CreatedBy       string    `gorm:"index;not null" json:"createdBy"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+func (s ScheduledRoom) EndTime() time.Time {
+	return s.StartTime.Add(time.Duration(s.DurationMinutes) * time.Minute)
+}
+
+type ScheduledRoomAttendee struct {
+	ID              uint   `gorm:"primaryKey"`
+	ScheduledRoomID string `gorm:"uniqueIndex:idx_schedule_attendee;not null"`
+	Username        string `gorm:"uniqueIndex:idx_schedule_attendee;not null"`
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/nbursa/agoranet/controllers"
 	"github.com/nbursa/agoranet/middleware"
+	"github.com/nbursa/agoranet/services"
 )
 
 func SetupRoutes(app *fiber.App) {
@@ -24,11 +25,36 @@ func SetupRoutes(app *fiber.App) {
 
 	fmt.Println("✅ Auth routes registered: /auth/register, /auth/login, /auth/profile/:username")
 
-	uploadDir := "./uploads/avatars"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+	if err := os.MkdirAll(services.AvatarDir, os.ModePerm); err != nil {
 		panic("Failed to create upload directory")
 	}
-	app.Static("/uploads/avatars", uploadDir)
+	app.Static("/uploads/avatars", services.AvatarDir)
+
+	app.Get("/protocol/schema", controllers.GetProtocolSchema)
+
+	app.Get("/rooms", controllers.GetRoomDirectory)
+	app.Get("/rooms/:roomId/gallery", controllers.GetRoomGallery)
+	app.Get("/rooms/:roomId/reports", controllers.GetParticipationReports)
+	app.Get("/rooms/:roomId/minutes", controllers.ListMinutes)
+	app.Get("/rooms/:roomId/minutes/:id", controllers.GetMinutes)
+	app.Get("/rooms/:roomId/events", controllers.GetRoomEvents)
+
+	// Room artifacts are unlocked by the host's room token, not a JWT.
+	recordings := app.Group("/recordings")
+	recordings.Get("/rooms/:roomId", controllers.ListRoomRecordings)
+	recordings.Get("/:id", controllers.GetRecording)
+	recordings.Get("/:id/files/:name", controllers.DownloadRecordingFile)
+
+	// Signed, expiring links; the signature is the authorization.
+	app.Get("/media/:id", controllers.DownloadMedia)
+
+	// Livestreams are public so non-participants can tune in.
+	app.Get("/live/:id/:file", controllers.ServeLivestreamFile)
+
+	// Calendar apps cannot send auth headers; user feeds carry a signed token.
+	calendar := app.Group("/calendar")
+	calendar.Get("/rooms/:roomId.ics", controllers.RoomCalendarFeed)
+	calendar.Get("/users/:username.ics", controllers.UserCalendarFeed)
 
 	api := app.Group("/api", middleware.AuthMiddleware())
 	api.Get("/protected", func(c *fiber.Ctx) error {
@@ -37,5 +63,19 @@ func SetupRoutes(app *fiber.App) {
 
 	api.Post("/votes", controllers.SyncVotes)
 
-	fmt.Println("✅ API routes registered: /api/votes (POST)")
+	api.Get("/turn-credentials", controllers.GetTURNCredentials)
+
+	api.Post("/media", controllers.UploadMedia)
+	api.Post("/avatar", controllers.UploadAvatar)
+
+	api.Get("/schedules", controllers.ListScheduledRooms)
+	api.Post("/schedules", controllers.CreateScheduledRoom)
+	api.Get("/schedules/feed", controllers.GetCalendarFeedURL)
+	api.Get("/schedules/:id", controllers.GetScheduledRoom)
+	api.Put("/schedules/:id", controllers.UpdateScheduledRoom)
+	api.Delete("/schedules/:id", controllers.DeleteScheduledRoom)
+	api.Post("/schedules/:id/attend", controllers.AttendScheduledRoom)
+	api.Delete("/schedules/:id/attend", controllers.LeaveScheduledRoom)
+
+	fmt.Println("✅ API routes registered: /api/votes (POST), /api/turn-credentials, /api/media, /api/avatar, /api/schedules")
 }
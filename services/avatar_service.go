@@ -0,0 +1,45 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+// AvatarDir is served statically at /uploads/avatars.
+const AvatarDir = "./uploads/avatars"
+
+// StoreAvatar re-encodes an uploaded picture into a metadata-free square
+// avatar plus thumbnail and points the user's profile at it.
+func StoreAvatar(raw []byte, username string) (string, error) {
+	if int64(len(raw)) > MediaMaxBytes() {
+		return "", ErrMediaTooLarge
+	}
+
+	avatar, thumb, err := processAvatar(raw)
+	if err != nil {
+		return "", err
+	}
+
+	// Name files by content so the username never appears in a URL.
+	sum := sha256.Sum256(avatar)
+	name := hex.EncodeToString(sum[:16])
+	if err := os.WriteFile(filepath.Join(AvatarDir, name+".png"), avatar, 0o644); err != nil {
+		return "", fmt.Errorf("failed to store avatar: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(AvatarDir, name+"_thumb.png"), thumb, 0o644); err != nil {
+		return "", fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	url := "/uploads/avatars/" + name + ".png"
+	result := config.DB.Model(&models.User{}).Where("username = ?", username).Update("avatar", url)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to update avatar: %w", result.Error)
+	}
+	return url, nil
+}
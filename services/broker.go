@@ -0,0 +1,185 @@
+package services
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Broker connects signaling instances. Each room runs on one instance, its
+// home, which holds a renewable lease on it; other instances relay their
+// clients' traffic there over pub/sub channels.
+type Broker interface {
+	// Publish delivers payload to the channel's current subscribers.
+	Publish(channel string, payload []byte) error
+	// Subscribe calls handler for each message on channel, in order.
+	Subscribe(channel string, handler func(payload []byte)) error
+	// ClaimRoom returns the room's home, making it instance if the room
+	// has none or its lease has lapsed.
+	ClaimRoom(roomID, instance string, ttl time.Duration) (string, error)
+	// RenewRoom extends instance's lease on the room. It reports false
+	// if the room has another home.
+	RenewRoom(roomID, instance string, ttl time.Duration) (bool, error)
+	// RoomHome returns the instance holding the room's lease, or "" if
+	// its lease has lapsed.
+	RoomHome(roomID string) (string, error)
+	// ListRoom publishes the room's directory entry for ttl; a nil entry
+	// withdraws it.
+	ListRoom(roomID string, entry []byte, ttl time.Duration) error
+	// Listings returns every entry whose ttl has not run out.
+	Listings() ([][]byte, error)
+}
+
+// newBroker picks the broker from BROKER_URL: a redis:// or rediss:// URL,
+// or empty for the in-memory broker of a single instance.
+func newBroker() (Broker, error) {
+	url := os.Getenv("BROKER_URL")
+	if url == "" {
+		return newMemoryBroker(), nil
+	}
+	return newRedisBroker(url)
+}
+
+// memoryBroker keeps channels, leases and listings in process.
+type memoryBroker struct {
+	mu       sync.Mutex
+	subs     map[string][]*memorySubscription
+	leases   map[string]roomLease
+	listings map[string]roomListing
+}
+
+type roomLease struct {
+	holder  string
+	expires time.Time
+}
+
+type roomListing struct {
+	entry   []byte
+	expires time.Time
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		subs:     make(map[string][]*memorySubscription),
+		leases:   make(map[string]roomLease),
+		listings: make(map[string]roomListing),
+	}
+}
+
+func (b *memoryBroker) Publish(channel string, payload []byte) error {
+	b.mu.Lock()
+	subs := b.subs[channel]
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.push(append([]byte(nil), payload...))
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(channel string, handler func(payload []byte)) error {
+	sub := &memorySubscription{wake: make(chan struct{}, 1)}
+	go sub.run(handler)
+
+	b.mu.Lock()
+	b.subs[channel] = append(b.subs[channel], sub)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *memoryBroker) ClaimRoom(roomID, instance string, ttl time.Duration) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if lease, ok := b.leases[roomID]; ok && lease.holder != instance && now.Before(lease.expires) {
+		return lease.holder, nil
+	}
+	b.leases[roomID] = roomLease{holder: instance, expires: now.Add(ttl)}
+	return instance, nil
+}
+
+func (b *memoryBroker) RenewRoom(roomID, instance string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lease, ok := b.leases[roomID]; ok && lease.holder != instance && time.Now().Before(lease.expires) {
+		return false, nil
+	}
+	b.leases[roomID] = roomLease{holder: instance, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (b *memoryBroker) RoomHome(roomID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lease, ok := b.leases[roomID]; ok && time.Now().Before(lease.expires) {
+		return lease.holder, nil
+	}
+	return "", nil
+}
+
+func (b *memoryBroker) ListRoom(roomID string, entry []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if entry == nil {
+		delete(b.listings, roomID)
+		return nil
+	}
+	b.listings[roomID] = roomListing{entry: append([]byte(nil), entry...), expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (b *memoryBroker) Listings() ([][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	entries := [][]byte{}
+	for roomID, listing := range b.listings {
+		if now.Before(listing.expires) {
+			entries = append(entries, listing.entry)
+		} else {
+			delete(b.listings, roomID)
+		}
+	}
+	return entries, nil
+}
+
+// memorySubscription queues without bound, so publishing never blocks on
+// a slow handler, and hands messages over in order.
+type memorySubscription struct {
+	mu    sync.Mutex
+	queue [][]byte
+	wake  chan struct{}
+}
+
+func (s *memorySubscription) push(payload []byte) {
+	s.mu.Lock()
+	s.queue = append(s.queue, payload)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySubscription) run(handler func(payload []byte)) {
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			payload := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			handler(payload)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	roomLeasePrefix   = "agora:room:"
+	roomListingPrefix = "agora:listing:"
+)
+
+// renewLease extends a lease only for its current holder.
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// redisBroker speaks the Redis protocol: PUBLISH/SUBSCRIBE for channels,
+// a key per room, set with NX and a TTL, for leases, and another with a
+// TTL for its directory listing.
+type redisBroker struct {
+	client *redis.Client
+}
+
+func newRedisBroker(url string) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BROKER_URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis unreachable: %w", err)
+	}
+	return &redisBroker{client: client}, nil
+}
+
+func (b *redisBroker) Publish(channel string, payload []byte) error {
+	return b.client.Publish(context.Background(), channel, payload).Err()
+}
+
+func (b *redisBroker) Subscribe(channel string, handler func(payload []byte)) error {
+	ctx := context.Background()
+	sub := b.client.Subscribe(ctx, channel)
+	// Wait for the confirmation so nothing published after we return is
+	// missed.
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return err
+	}
+
+	go func() {
+		for msg := range sub.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return nil
+}
+
+func (b *redisBroker) ClaimRoom(roomID, instance string, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+	key := roomLeasePrefix + roomID
+	for {
+		claimed, err := b.client.SetNX(ctx, key, instance, ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return instance, nil
+		}
+
+		home, err := b.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			continue // expired between the two calls
+		}
+		if err != nil {
+			return "", err
+		}
+		if home == instance {
+			_, err = b.RenewRoom(roomID, instance, ttl)
+		}
+		return home, err
+	}
+}
+
+func (b *redisBroker) RenewRoom(roomID, instance string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	key := roomLeasePrefix + roomID
+	renewed, err := renewLease.Run(ctx, b.client, []string{key}, instance, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	if renewed == 1 {
+		return true, nil
+	}
+
+	// A lapsed lease can simply be taken again.
+	home, err := b.ClaimRoom(roomID, instance, ttl)
+	return home == instance, err
+}
+
+func (b *redisBroker) RoomHome(roomID string) (string, error) {
+	home, err := b.client.Get(context.Background(), roomLeasePrefix+roomID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return home, err
+}
+
+func (b *redisBroker) ListRoom(roomID string, entry []byte, ttl time.Duration) error {
+	ctx := context.Background()
+	if entry == nil {
+		return b.client.Del(ctx, roomListingPrefix+roomID).Err()
+	}
+	return b.client.Set(ctx, roomListingPrefix+roomID, entry, ttl).Err()
+}
+
+func (b *redisBroker) Listings() ([][]byte, error) {
+	ctx := context.Background()
+	keys := []string{}
+	iter := b.client.Scan(ctx, 0, roomListingPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := [][]byte{}
+	if len(keys) == 0 {
+		return entries, nil
+	}
+	values, err := b.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		// A key that expired since the scan comes back nil.
+		if s, ok := v.(string); ok {
+			entries = append(entries, []byte(s))
+		}
+	}
+	return entries, nil
+}
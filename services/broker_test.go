@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	testBrokerContract(t, newMemoryBroker(), func(ttl time.Duration) {
+		time.Sleep(ttl + 20*time.Millisecond)
+	})
+}
+
+func TestRedisBroker(t *testing.T) {
+	server := miniredis.RunT(t)
+	b, err := newRedisBroker("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBrokerContract(t, b, server.FastForward)
+}
+
+// testBrokerContract checks what the cluster relies on from a Broker.
+// lapse lets ttl pass as far as the broker's leases are concerned.
+func testBrokerContract(t *testing.T, b Broker, lapse func(ttl time.Duration)) {
+	t.Run("channels", func(t *testing.T) {
+		got := make(chan string, 100)
+		if err := b.Subscribe("contract:a", func(payload []byte) { got <- string(payload) }); err != nil {
+			t.Fatal(err)
+		}
+		other := make(chan string, 1)
+		if err := b.Subscribe("contract:b", func(payload []byte) { other <- string(payload) }); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			if err := b.Publish("contract:a", []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 100; i++ {
+			select {
+			case payload := <-got:
+				if payload != fmt.Sprint(i) {
+					t.Fatalf("message %d arrived as %q", i, payload)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("message %d never arrived", i)
+			}
+		}
+		select {
+		case payload := <-other:
+			t.Fatalf("another channel received %q", payload)
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		const ttl = 100 * time.Millisecond
+		expectHome := func(want string) {
+			t.Helper()
+			if home, err := b.RoomHome("contract-room"); err != nil || home != want {
+				t.Fatalf("RoomHome = %q, %v; want %q", home, err, want)
+			}
+		}
+		expectClaim := func(instance, want string) {
+			t.Helper()
+			if home, err := b.ClaimRoom("contract-room", instance, ttl); err != nil || home != want {
+				t.Fatalf("ClaimRoom by %s = %q, %v; want %q", instance, home, err, want)
+			}
+		}
+		expectRenew := func(instance string, want bool) {
+			t.Helper()
+			if ok, err := b.RenewRoom("contract-room", instance, ttl); err != nil || ok != want {
+				t.Fatalf("RenewRoom by %s = %v, %v; want %v", instance, ok, err, want)
+			}
+		}
+
+		expectHome("")
+		expectClaim("a", "a")
+		expectClaim("b", "a")
+		expectClaim("a", "a")
+		expectHome("a")
+		expectRenew("a", true)
+		expectRenew("b", false)
+		expectHome("a")
+
+		lapse(ttl)
+		expectHome("")
+		expectRenew("b", true)
+		expectHome("b")
+		expectClaim("a", "b")
+	})
+
+	t.Run("listings", func(t *testing.T) {
+		const ttl = 100 * time.Millisecond
+		expectListings := func(want ...string) {
+			t.Helper()
+			got, err := b.Listings()
+			if err != nil {
+				t.Fatal(err)
+			}
+			have := map[string]bool{}
+			for _, entry := range got {
+				have[string(entry)] = true
+			}
+			if len(got) != len(want) {
+				t.Fatalf("Listings = %q, want %q", got, want)
+			}
+			for _, w := range want {
+				if !have[w] {
+					t.Fatalf("Listings = %q, want %q", got, want)
+				}
+			}
+		}
+
+		expectListings()
+		_ = b.ListRoom("contract-x", []byte("x1"), ttl)
+		_ = b.ListRoom("contract-y", []byte("y1"), time.Hour)
+		expectListings("x1", "y1")
+		_ = b.ListRoom("contract-x", []byte("x2"), ttl)
+		expectListings("x2", "y1")
+		_ = b.ListRoom("contract-y", nil, ttl)
+		expectListings("x2")
+
+		lapse(ttl)
+		expectListings()
+	})
+}
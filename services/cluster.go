@@ -0,0 +1,586 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A room lives on one instance, its home, which claims it through the
+// broker. A client connected elsewhere keeps its socket on its origin
+// instance; everything it sends is relayed to the home, where a proxy
+// client joins the room in its place and relays back whatever the room
+// sends it. Rooms, votes, forwarding and broadcasts then work unchanged.
+
+var (
+	broker     Broker = newMemoryBroker()
+	instanceID        = newInstanceID()
+)
+
+// roomLeaseTTL is how long a home keeps a room without renewing. An
+// instance that dies loses its rooms after this long.
+func roomLeaseTTL() time.Duration {
+	return envSeconds("ROOM_LEASE_SECONDS", 30*time.Second)
+}
+
+// newInstanceID reads INSTANCE_ID, or makes one up from the dyno name.
+func newInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	if dyno := os.Getenv("DYNO"); dyno != "" {
+		return dyno + "-" + hex.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+func instanceChannel(instance string) string {
+	return "agora:instance:" + instance
+}
+
+// StartCluster connects to the broker named by BROKER_URL and starts
+// taking relayed traffic. Without BROKER_URL the instance runs alone.
+func StartCluster() error {
+	b, err := newBroker()
+	if err != nil {
+		return err
+	}
+	if err := b.Subscribe(instanceChannel(instanceID), handleRelay); err != nil {
+		return err
+	}
+	broker = b
+
+	go renewRoomLeases()
+	if os.Getenv("BROKER_URL") != "" {
+		log.Printf("🛰️ Signaling instance %s joined the cluster", instanceID)
+	}
+	return nil
+}
+
+// renewRoomLeases keeps this instance the home of its open rooms.
+func renewRoomLeases() {
+	ttl := roomLeaseTTL()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, room := range rooms.all() {
+			ok, err := broker.RenewRoom(room.ID, instanceID, ttl)
+			switch {
+			case err != nil:
+				log.Printf("❌ Failed to renew lease on room %s: %v", room.ID, err)
+			case !ok:
+				log.Printf("⚠️ Room %s is now homed on another instance", room.ID)
+				evacuateRoom(room)
+			default:
+				room.mu.Lock()
+				entry, listed := directoryEntry(room)
+				room.mu.Unlock()
+				publishListing(room.ID, entry, listed)
+			}
+		}
+		checkHomes()
+	}
+}
+
+// checkHomes finds clients whose room's home no longer holds its lease,
+// because it died or gave the room up, and sends them to wherever the
+// room is now.
+func checkHomes() {
+	holders := make(map[string]string)
+	for _, client := range clients.all() {
+		client.mu.Lock()
+		home, link, roomID := client.home, client.link, client.homeRoom
+		client.mu.Unlock()
+		if home == "" {
+			continue
+		}
+
+		holder, looked := holders[roomID]
+		if !looked {
+			var err error
+			if holder, err = broker.RoomHome(roomID); err != nil {
+				log.Printf("❌ Failed to look up the home of room %s: %v", roomID, err)
+				continue
+			}
+			holders[roomID] = holder
+		}
+		if holder != home {
+			rebindClient(client, home, link)
+		}
+	}
+}
+
+// evacuateRoom hands a room whose lease went to another instance over to
+// its new home. Members connected here join it again, which takes them
+// there, and the origins of proxies are told to do the same for theirs.
+// The local copy then closes.
+func evacuateRoom(room *Room) {
+	room.mu.Lock()
+	if current, ok := rooms.get(room.ID); !ok || current != room {
+		room.mu.Unlock()
+		return
+	}
+	closeBreakouts(room.ID)
+	hostID := room.HostID
+	members := make([]*Client, 0, len(room.Clients))
+	for _, c := range room.Clients {
+		members = append(members, c)
+	}
+	room.mu.Unlock()
+
+	for _, member := range members {
+		member.mu.Lock()
+		origin, link := member.origin, member.link
+		member.mu.Unlock()
+
+		if origin == "" {
+			join, _ := json.Marshal(map[string]interface{}{
+				"type":      "join",
+				"roomId":    room.ID,
+				"isCreator": member.ID == hostID,
+			})
+			rejoin(member, join)
+			continue
+		}
+		// The origin drops the binding first, so it ignores the close
+		// that removing the proxy sends after.
+		_ = relayTo(origin, relayEnvelope{Op: opRebind, Conn: member.ID, Link: link})
+		removeClient(member)
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if current, ok := rooms.get(room.ID); !ok || current != room || len(room.Clients) > 0 {
+		return
+	}
+	if parent, ok := rooms.get(room.ParentID); ok {
+		for i, id := range parent.Breakouts {
+			if id == room.ID {
+				parent.Breakouts = append(parent.Breakouts[:i:i], parent.Breakouts[i+1:]...)
+				break
+			}
+		}
+		broadcastRoomState(parent.ID)
+	}
+	rooms.remove(room)
+	log.Printf("🛰️ Handed room %s over to its new home", room.ID)
+}
+
+// claimRoom finds the room's home, claiming it for this instance if it has
+// none. If the broker is unreachable the room is served locally.
+func claimRoom(roomID string) string {
+	home, err := broker.ClaimRoom(roomID, instanceID, roomLeaseTTL())
+	if err != nil {
+		log.Printf("❌ Failed to claim room %s: %v", roomID, err)
+		return instanceID
+	}
+	return home
+}
+
+// Relay operations. The origin sends attach, message, drop, resume and
+// detach to the home; the home sends deliver, close and rebind back.
+const (
+	opAttach  = "attach"
+	opMessage = "message"
+	opDrop    = "drop"
+	opResume  = "resume"
+	opDetach  = "detach"
+	opDeliver = "deliver"
+	opClose   = "close"
+	opRebind  = "rebind"
+)
+
+type relayEnvelope struct {
+	Op      string          `json:"op"`
+	From    string          `json:"from"`
+	Conn    string          `json:"conn"`
+	Link    string          `json:"link"`
+	UserID  string          `json:"userId,omitempty"`
+	Account string          `json:"account,omitempty"`
+	Reopen  bool            `json:"reopen,omitempty"`
+	Kind    string          `json:"kind,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	LastSeq int64           `json:"lastSeq,omitempty"`
+}
+
+// pendingRelay is an envelope a client queued for another instance.
+type pendingRelay struct {
+	instance string
+	env      relayEnvelope
+}
+
+// queueRelay queues env for instance behind whatever the client queued
+// before. Caller must hold client.mu, and call flushRelays once it has
+// released it.
+func (c *Client) queueRelay(instance string, env relayEnvelope) {
+	c.relays = append(c.relays, pendingRelay{instance: instance, env: env})
+}
+
+// flushRelays publishes the client's queued relays in order. The broker is
+// never called under client.mu, so a slow broker cannot stall the client.
+func (c *Client) flushRelays() {
+	c.relayMu.Lock()
+	defer c.relayMu.Unlock()
+	for {
+		c.mu.Lock()
+		if len(c.relays) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		next := c.relays[0]
+		c.relays = c.relays[1:]
+		c.mu.Unlock()
+
+		_ = relayTo(next.instance, next.env)
+	}
+}
+
+func relayTo(instance string, env relayEnvelope) error {
+	env.From = instanceID
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if err := broker.Publish(instanceChannel(instance), data); err != nil {
+		log.Printf("❌ Failed to relay %s for %s to %s: %v", env.Op, env.Conn, instance, err)
+		return err
+	}
+	return nil
+}
+
+func handleRelay(payload []byte) {
+	var env relayEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log.Println("❌ Invalid relay message:", err)
+		return
+	}
+
+	switch env.Op {
+	case opDeliver, opClose:
+		deliverRelayed(env)
+	case opRebind:
+		if client, ok := clients.get(env.Conn); ok {
+			rebindClient(client, env.From, env.Link)
+		}
+	default:
+		queueHomeOp(env)
+	}
+}
+
+// routeMessage relays a message from a client whose room is on another
+// instance, and binds the client to a room's home when it joins one. It
+// returns false if the message is for this instance.
+func routeMessage(client *Client, msg clientMessage, raw []byte) bool {
+	defer client.flushRelays()
+
+	join, ok := msg.(*joinMessage)
+	if !ok {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		if client.home == "" {
+			return false
+		}
+		client.queueRelay(client.home, relayEnvelope{Op: opMessage, Conn: client.ID, Link: client.link, Data: raw})
+		return true
+	}
+	return routeJoin(client, join, raw, false)
+}
+
+// routeJoin binds the client to the home of the room it joins, as
+// routeMessage does, and returns false if that home is this instance.
+// reopen is passed on to registerClient there.
+func routeJoin(client *Client, join *joinMessage, raw []byte, reopen bool) bool {
+	defer client.flushRelays()
+
+	client.mu.Lock()
+	home := client.home
+	client.mu.Unlock()
+
+	target := claimRoom(join.RoomID)
+	if home != "" {
+		if target == home {
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			if client.home != home {
+				return true
+			}
+			client.homeRoom, client.homeJoin = join.RoomID, raw
+			client.queueRelay(home, relayEnvelope{Op: opMessage, Conn: client.ID, Link: client.link, Data: raw})
+			return true
+		}
+		unbindClient(client)
+	}
+	if target == instanceID {
+		return false
+	}
+
+	// Joining another room leaves the current one.
+	if current, inRoom := lockClientRoom(client); inRoom {
+		detachFromRoom(client)
+		current.mu.Unlock()
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.evicted {
+		return true
+	}
+	client.home = target
+	client.link = uuid.New().String()
+	client.homeRoom, client.homeJoin = join.RoomID, raw
+	client.queueRelay(target, relayEnvelope{
+		Op:      opAttach,
+		Conn:    client.ID,
+		Link:    client.link,
+		UserID:  client.UserID,
+		Account: client.account,
+		Reopen:  reopen,
+		Data:    raw,
+	})
+	log.Printf("🛰️ %s joins room %s on instance %s", client.ID, join.RoomID, target)
+	return true
+}
+
+// unbindClient takes a client out of its remote room.
+func unbindClient(client *Client) {
+	client.mu.Lock()
+	if client.home != "" {
+		client.queueRelay(client.home, relayEnvelope{Op: opDetach, Conn: client.ID, Link: client.link})
+	}
+	client.home, client.link, client.homeRoom, client.homeJoin = "", "", "", nil
+	client.mu.Unlock()
+
+	client.flushRelays()
+}
+
+// rebindClient drops the client's binding to home, which no longer has its
+// room, and sends it through its join again to find the room's new home.
+// Nothing happens if the client has moved on since.
+func rebindClient(client *Client, home, link string) {
+	client.mu.Lock()
+	if client.home != home || client.link != link {
+		client.mu.Unlock()
+		return
+	}
+	join := client.homeJoin
+	client.home, client.link, client.homeRoom, client.homeJoin = "", "", "", nil
+	client.mu.Unlock()
+
+	log.Printf("🛰️ Room of %s left instance %s; joining it again", client.ID, home)
+	rejoin(client, join)
+}
+
+// rejoin replays a join for a client whose room moved.
+func rejoin(client *Client, raw []byte) {
+	msg, err := decodeMessage(raw, minProtocolVersion)
+	if err != nil {
+		return
+	}
+	join, ok := msg.(*joinMessage)
+	if !ok {
+		return
+	}
+	if !routeJoin(client, join, raw, true) {
+		registerClient(join.RoomID, client, join.IsCreator, true)
+		return
+	}
+
+	// A suspended client's new proxy starts out suspended too.
+	client.mu.Lock()
+	if client.suspended && client.home != "" {
+		client.queueRelay(client.home, relayEnvelope{Op: opDrop, Conn: client.ID, Link: client.link})
+	}
+	client.mu.Unlock()
+	client.flushRelays()
+}
+
+// deliverRelayed passes what a home sent to the client it was meant for,
+// unless the client has since moved on.
+func deliverRelayed(env relayEnvelope) {
+	client, ok := clients.get(env.Conn)
+	if !ok {
+		return
+	}
+
+	client.mu.Lock()
+	bound := client.home == env.From && client.link == env.Link
+	if bound && env.Op == opClose {
+		client.home, client.link, client.homeRoom, client.homeJoin = "", "", "", nil
+	}
+	out := client.out
+	client.mu.Unlock()
+	if !bound {
+		return
+	}
+
+	if env.Op == opClose {
+		removeClient(client)
+		return
+	}
+	out.enqueue(outboxEntry{kind: env.Kind, data: env.Data})
+}
+
+// Operations from other instances run in order per connection, each
+// connection in its own lane so one cannot hold up the rest.
+var (
+	relayLanesMu sync.Mutex
+	relayLanes   = make(map[string][]relayEnvelope)
+)
+
+func queueHomeOp(env relayEnvelope) {
+	key := env.From + "/" + env.Conn
+
+	relayLanesMu.Lock()
+	queued, running := relayLanes[key]
+	relayLanes[key] = append(queued, env)
+	relayLanesMu.Unlock()
+
+	if !running {
+		go drainLane(key)
+	}
+}
+
+func drainLane(key string) {
+	for {
+		relayLanesMu.Lock()
+		queued := relayLanes[key]
+		if len(queued) == 0 {
+			delete(relayLanes, key)
+			relayLanesMu.Unlock()
+			return
+		}
+		env := queued[0]
+		relayLanes[key] = queued[1:]
+		relayLanesMu.Unlock()
+
+		handleHomeOp(env)
+	}
+}
+
+func handleHomeOp(env relayEnvelope) {
+	if env.Op == opAttach {
+		attachProxy(env)
+		return
+	}
+
+	proxy, ok := clients.get(env.Conn)
+	if !ok {
+		return
+	}
+	proxy.mu.Lock()
+	current := proxy.origin == env.From && proxy.link == env.Link
+	proxy.mu.Unlock()
+	if !current {
+		return
+	}
+
+	switch env.Op {
+	case opMessage:
+		// The origin already checked it under the client's version.
+		if msg, err := decodeMessage(env.Data, minProtocolVersion); err == nil {
+			handleMessage(proxy, msg)
+		}
+	case opDrop:
+		dropConnection(proxy, nil)
+	case opResume:
+		resumeProxy(proxy, env.LastSeq)
+	case opDetach:
+		removeClient(proxy)
+	}
+}
+
+// attachProxy registers a client connected to another instance and joins
+// it to the room it asked for, applying the duplicate ID policy as for a
+// local connection. Under multi the origin already made the ID unique.
+func attachProxy(env relayEnvelope) {
+	join, err := decodeMessage(env.Data, minProtocolVersion)
+	if err != nil {
+		return
+	}
+
+	proxy := &Client{
+		ID:      env.Conn,
+		UserID:  env.UserID,
+		account: env.Account,
+		origin:  env.From,
+		link:    env.Link,
+	}
+	proxy.out = newRelayOutbox(env.From, env.Conn, env.Link)
+
+	for !clients.add(proxy) {
+		existing, taken := clients.get(proxy.ID)
+		if !taken {
+			continue
+		}
+		if duplicateIDPolicy() != duplicateTakeover || !provesIdentity(existing, env.Account) {
+			log.Printf("⛔ Rejected duplicate connection for %s from %s", proxy.ID, env.From)
+			proxy.out.push(duplicateIDError())
+			proxy.out.shutdown()
+			return
+		}
+		log.Printf("🔁 Connection from %s takes over %s", env.From, proxy.ID)
+		existing.send(&bareMessage{typed("session-replaced")})
+		removeClient(existing)
+	}
+
+	log.Printf("🛰️ %s attached from instance %s", proxy.ID, env.From)
+	if m, ok := join.(*joinMessage); ok && env.Reopen {
+		registerClient(m.RoomID, proxy, m.IsCreator, true)
+		return
+	}
+	handleMessage(proxy, join)
+}
+
+// newRelayOutbox queues messages for a client connected to another
+// instance and relays them there.
+func newRelayOutbox(instance, clientID, link string) *outbox {
+	o := &outbox{id: clientID, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	o.write = func(entry outboxEntry) error {
+		return relayTo(instance, relayEnvelope{
+			Op:   opDeliver,
+			Conn: clientID,
+			Link: link,
+			Kind: entry.kind,
+			Data: entry.data,
+		})
+	}
+	o.hangUp = func() {
+		_ = relayTo(instance, relayEnvelope{Op: opClose, Conn: clientID, Link: link})
+	}
+	go o.run()
+	return o
+}
+
+// resumeProxy brings a proxy back after its client resumed on the origin.
+func resumeProxy(proxy *Client, lastSeq int64) {
+	room, inRoom := lockClientRoom(proxy)
+	if inRoom {
+		defer room.mu.Unlock()
+	}
+
+	proxy.mu.Lock()
+	if proxy.evicted {
+		proxy.mu.Unlock()
+		return
+	}
+	if proxy.graceTimer != nil {
+		proxy.graceTimer.Stop()
+		proxy.graceTimer = nil
+	}
+	wasSuspended := proxy.suspended
+	proxy.suspended = false
+	proxy.mu.Unlock()
+
+	if inRoom && room.Clients[proxy.ID] == proxy {
+		catchUp(room, proxy, lastSeq, wasSuspended)
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useMemoryBroker gives the test a broker of its own, subscribed as
+// StartCluster would subscribe this instance.
+func useMemoryBroker(t *testing.T) *memoryBroker {
+	t.Helper()
+	b := newMemoryBroker()
+	if err := b.Subscribe(instanceChannel(instanceID), handleRelay); err != nil {
+		t.Fatal(err)
+	}
+	previous := broker
+	broker = b
+	t.Cleanup(func() { broker = previous })
+	return b
+}
+
+// fakeInstance stands in for another instance on the broker: it records
+// what is relayed to it and relays to this instance as that one would.
+type fakeInstance struct {
+	t  *testing.T
+	id string
+
+	mu  sync.Mutex
+	got []relayEnvelope
+}
+
+func newFakeInstance(t *testing.T, b Broker, id string) *fakeInstance {
+	t.Helper()
+	f := &fakeInstance{t: t, id: id}
+	err := b.Subscribe(instanceChannel(id), func(payload []byte) {
+		var env relayEnvelope
+		if err := json.Unmarshal(payload, &env); err != nil {
+			t.Errorf("%s got an invalid relay: %v", id, err)
+			return
+		}
+		f.mu.Lock()
+		f.got = append(f.got, env)
+		f.mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *fakeInstance) relay(env relayEnvelope) {
+	env.From = f.id
+	data, err := json.Marshal(env)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err := broker.Publish(instanceChannel(instanceID), data); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// find returns the index of the first relay of op that match accepts, or
+// -1.
+func (f *fakeInstance) find(op string, match func(env relayEnvelope) bool) (int, relayEnvelope) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, env := range f.got {
+		if env.Op == op && match(env) {
+			return i, env
+		}
+	}
+	return -1, relayEnvelope{}
+}
+
+func (f *fakeInstance) waitFor(op string, match func(env relayEnvelope) bool) relayEnvelope {
+	f.t.Helper()
+	var found relayEnvelope
+	waitUntil(f.t, f.id+" gets "+op, func() bool {
+		i, env := f.find(op, match)
+		found = env
+		return i >= 0
+	})
+	return found
+}
+
+func TestRelayToRemoteHome(t *testing.T) {
+	b := useMemoryBroker(t)
+	home := newFakeInstance(t, b, "test-home")
+	if _, err := b.ClaimRoom("relay-remote", home.id, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	p := connectPeer(t, "relay-alice")
+	p.join("relay-remote", false)
+	attach := home.waitFor(opAttach, func(env relayEnvelope) bool { return env.Conn == p.client.ID })
+	if attach.UserID != "relay-alice" || attach.Link == "" {
+		t.Fatalf("unexpected attach %+v", attach)
+	}
+	if _, inRoom := rooms.get("relay-remote"); inRoom {
+		t.Fatal("room homed elsewhere was opened here")
+	}
+
+	p.send(map[string]interface{}{"type": "speaking", "isSpeaking": true})
+	home.waitFor(opMessage, func(env relayEnvelope) bool { return env.Link == attach.Link })
+
+	// Deliveries on a stale link are dropped.
+	delivery := func(title string) json.RawMessage {
+		return json.RawMessage(`{"type":"agenda-item","title":"` + title + `"}`)
+	}
+	titled := func(title string) func(msg map[string]interface{}) bool {
+		return func(msg map[string]interface{}) bool { return msg["title"] == title }
+	}
+	home.relay(relayEnvelope{Op: opDeliver, Conn: p.client.ID, Link: "stale", Kind: "agenda-item", Data: delivery("stale")})
+	home.relay(relayEnvelope{Op: opDeliver, Conn: p.client.ID, Link: attach.Link, Kind: "agenda-item", Data: delivery("current")})
+	waitUntil(t, "the delivery arrives", func() bool { return p.saw("agenda-item", titled("current")) })
+	if p.saw("agenda-item", titled("stale")) {
+		t.Error("delivery on a stale link reached the client")
+	}
+
+	// The home gave the room up, and its lease went elsewhere.
+	if ok, _ := b.RenewRoom("relay-remote", home.id, time.Minute); !ok {
+		t.Fatal("home could not renew its lease")
+	}
+	home.relay(relayEnvelope{Op: opRebind, Conn: p.client.ID, Link: attach.Link})
+	reattach := home.waitFor(opAttach, func(env relayEnvelope) bool {
+		return env.Conn == p.client.ID && env.Link != attach.Link
+	})
+
+	// The home died: once its lease lapses, the room reopens here.
+	b.mu.Lock()
+	delete(b.leases, "relay-remote")
+	b.mu.Unlock()
+	checkHomes()
+
+	p.client.mu.Lock()
+	bound := p.client.home
+	p.client.mu.Unlock()
+	if bound != "" {
+		t.Fatalf("client still bound to %s", bound)
+	}
+	room, ok := lockRoom("relay-remote")
+	if !ok {
+		t.Fatal("room was not reopened here")
+	}
+	inRoom := room.Clients[p.client.ID] == p.client
+	room.mu.Unlock()
+	if !inRoom {
+		t.Fatal("client did not rejoin the reopened room")
+	}
+	if i, _ := home.find(opMessage, func(env relayEnvelope) bool { return env.Link == reattach.Link }); i >= 0 {
+		t.Error("client kept relaying to the dead home")
+	}
+}
+
+func TestRelayFromRemoteOrigin(t *testing.T) {
+	b := useMemoryBroker(t)
+	origin := newFakeInstance(t, b, "test-origin")
+	next := newFakeInstance(t, b, "test-next-home")
+
+	host := connectPeer(t, "relay-host")
+	host.join("relay-local", true)
+
+	join, _ := json.Marshal(map[string]interface{}{"type": "join", "roomId": "relay-local"})
+	origin.relay(relayEnvelope{Op: opAttach, Conn: "relay-guest", Link: "link-1", UserID: "relay-guest", Data: join})
+	origin.waitFor(opDeliver, func(env relayEnvelope) bool { return env.Conn == "relay-guest" && env.Kind == "room-state" })
+
+	speaking, _ := json.Marshal(map[string]interface{}{"type": "speaking", "isSpeaking": true})
+	origin.relay(relayEnvelope{Op: opMessage, Conn: "relay-guest", Link: "link-1", Data: speaking})
+	waitUntil(t, "the host hears the relayed guest", func() bool {
+		return host.saw("speaking", func(msg map[string]interface{}) bool { return msg["userId"] == "relay-guest" })
+	})
+
+	// Another instance took the lease, say after this one stalled.
+	b.mu.Lock()
+	b.leases["relay-local"] = roomLease{holder: next.id, expires: time.Now().Add(time.Minute)}
+	b.mu.Unlock()
+	room, _ := rooms.get("relay-local")
+	evacuateRoom(room)
+
+	rebind := origin.waitFor(opRebind, func(env relayEnvelope) bool { return env.Conn == "relay-guest" })
+	if rebind.Link != "link-1" {
+		t.Errorf("rebind names link %q", rebind.Link)
+	}
+	next.waitFor(opAttach, func(env relayEnvelope) bool { return env.Conn == host.client.ID })
+	if _, open := rooms.get("relay-local"); open {
+		t.Error("room is still open after its lease was lost")
+	}
+	if _, registered := clients.get("relay-guest"); registered {
+		t.Error("proxy is still registered after its room left")
+	}
+
+	// The proxy's close follows the rebind, so the origin ignores it.
+	origin.waitFor(opClose, func(env relayEnvelope) bool { return env.Conn == "relay-guest" })
+	rebindAt, _ := origin.find(opRebind, func(env relayEnvelope) bool { return env.Conn == "relay-guest" })
+	closeAt, _ := origin.find(opClose, func(env relayEnvelope) bool { return env.Conn == "relay-guest" })
+	if closeAt < rebindAt {
+		t.Error("close reached the origin before rebind")
+	}
+}
+
+func TestDirectoryListsRoomsHomedElsewhere(t *testing.T) {
+	b := useMemoryBroker(t)
+
+	host := connectPeer(t, "directory-host")
+	host.join("directory-local", true)
+	host.send(map[string]interface{}{"type": "update-room-info", "title": "Local budget", "listed": true})
+
+	// Another instance's home publishes its room as it renews the lease.
+	remote, _ := json.Marshal(DirectoryEntry{RoomID: "directory-remote", Title: "Remote budget", Tags: []string{}, ParticipantCount: 3})
+	if err := b.ListRoom("directory-remote", remote, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]int{}
+	for _, e := range ListedRooms("budget", "") {
+		found[e.RoomID] = e.ParticipantCount
+	}
+	if len(found) != 2 || found["directory-remote"] != 3 || found["directory-local"] != 1 {
+		t.Fatalf("directory lists %v, want the local and the remote room", found)
+	}
+
+	// The local listing went to the broker too, so other instances see it.
+	listings, _ := b.Listings()
+	if len(listings) != 2 {
+		t.Fatalf("broker holds %d listings, want 2", len(listings))
+	}
+
+	host.send(map[string]interface{}{"type": "update-room-info", "listed": false})
+	b.ListRoom("directory-remote", nil, time.Minute)
+	if rooms := ListedRooms("budget", ""); len(rooms) != 0 {
+		t.Fatalf("withdrawn rooms are still listed: %v", rooms)
+	}
+	if listings, _ := b.Listings(); len(listings) != 0 {
+		t.Fatalf("broker still holds %d listings", len(listings))
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// These tests are meant for the race detector:
+//
+//	go test -race ./services/...
+
+func TestConcurrentRoomTraffic(t *testing.T) {
+	const roomCount, peerCount, steps = 4, 16, 150
+
+	roomIDs := make([]string, roomCount)
+	for i := range roomIDs {
+		roomIDs[i] = fmt.Sprintf("traffic-%d", i)
+		connectPeer(t, fmt.Sprintf("traffic-host-%d", i)).join(roomIDs[i], true)
+	}
+
+	peers := make([]*testPeer, peerCount)
+	for i := range peers {
+		peers[i] = connectPeer(t, fmt.Sprintf("traffic-peer-%d", i))
+	}
+
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(p *testPeer, seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for step := 0; step < steps; step++ {
+				switch rng.Intn(8) {
+				case 0, 1:
+					p.join(roomIDs[rng.Intn(roomCount)], false)
+				case 2:
+					p.send(map[string]interface{}{"type": "leave"})
+					p.reconnect()
+				case 3:
+					p.send(map[string]interface{}{"type": "create-vote", "question": "Lunch?"})
+				case 4:
+					p.send(map[string]interface{}{"type": "vote", "userId": p.client.ID, "value": "yes"})
+				case 5:
+					p.send(map[string]interface{}{"type": "speaking", "isSpeaking": rng.Intn(2) == 0})
+				case 6:
+					p.send(map[string]interface{}{"type": "reaction", "kind": "thumbs", "value": "up"})
+				case 7:
+					p.send(map[string]interface{}{"type": "raise-hand"})
+				}
+			}
+		}(p, int64(i))
+	}
+
+	// Readers that walk every room while the peers churn.
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ListedRooms("", "")
+			outboundState()
+			for _, id := range roomIDs {
+				if room, ok := lockRoom(id); ok {
+					broadcastRoomState(id)
+					room.mu.Unlock()
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+	checkRoomInvariants(t)
+}
+
+func TestResumeDuringEviction(t *testing.T) {
+	host := connectPeer(t, "evict-host")
+	host.join("evict-room", true)
+
+	for i := 0; i < 100; i++ {
+		guest := connectPeer(t, fmt.Sprintf("evict-guest-%d", i))
+		guest.join("evict-room", false)
+
+		guest.client.mu.Lock()
+		token := guest.client.resumeToken
+		guest.client.mu.Unlock()
+		dropConnection(guest.client, nil)
+		if !guest.client.isSuspended() {
+			t.Fatalf("%s was not suspended", guest.client.ID)
+		}
+
+		var resumed *Client
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			removeClient(guest.client)
+		}()
+		go func() {
+			defer wg.Done()
+			p := &testPeer{t: t}
+			resumed = resumeSession(newTestOutbox(p), guest.client.ID, token, 0, ProtocolVersion)
+		}()
+		wg.Wait()
+
+		room, _ := rooms.get("evict-room")
+		room.mu.Lock()
+		inRoom := room.Clients[guest.client.ID] == guest.client
+		room.mu.Unlock()
+		current, ok := clients.get(guest.client.ID)
+		registered := ok && current == guest.client
+
+		guest.client.mu.Lock()
+		evicted, suspended := guest.client.evicted, guest.client.suspended
+		guest.client.mu.Unlock()
+
+		switch {
+		case evicted && (inRoom || registered):
+			t.Fatalf("evicted %s is still in the room (%v) or registered (%v)", guest.client.ID, inRoom, registered)
+		case !evicted && (resumed == nil || suspended || !inRoom || !registered):
+			t.Fatalf("%s was neither evicted nor fully resumed", guest.client.ID)
+		}
+	}
+	checkRoomInvariants(t)
+}
+
+func TestConcurrentBreakoutMoves(t *testing.T) {
+	const guestCount, rounds = 12, 30
+
+	host := connectPeer(t, "breakout-host")
+	host.join("breakout-room", true)
+	guests := make([]*testPeer, guestCount)
+	guestIDs := make([]string, guestCount)
+	for i := range guests {
+		guestIDs[i] = fmt.Sprintf("breakout-guest-%d", i)
+		guests[i] = connectPeer(t, guestIDs[i])
+		guests[i].join("breakout-room", false)
+	}
+
+	family := func() []string {
+		parent, ok := lockRoom("breakout-room")
+		if !ok {
+			return nil
+		}
+		defer parent.mu.Unlock()
+		return append([]string{parent.ID}, parent.Breakouts...)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rng := rand.New(rand.NewSource(1))
+		for round := 0; round < rounds; round++ {
+			host.send(map[string]interface{}{"type": "create-breakouts", "count": 1 + rng.Intn(3), "mode": "random"})
+			for i := 0; i < 5; i++ {
+				ids := family()
+				host.send(map[string]interface{}{
+					"type":   "assign-breakout",
+					"userId": guestIDs[rng.Intn(guestCount)],
+					"roomId": ids[rng.Intn(len(ids))],
+				})
+			}
+			host.send(map[string]interface{}{"type": "breakout-broadcast", "message": "Two minutes left"})
+			host.send(map[string]interface{}{"type": "close-breakouts"})
+		}
+	}()
+
+	for i, g := range guests {
+		wg.Add(1)
+		go func(g *testPeer, seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for step := 0; step < rounds*3; step++ {
+				switch rng.Intn(4) {
+				case 0:
+					g.send(map[string]interface{}{"type": "leave"})
+					g.reconnect()
+					g.join("breakout-room", false)
+				case 1:
+					g.send(map[string]interface{}{"type": "speaking", "isSpeaking": true})
+				case 2:
+					g.send(map[string]interface{}{"type": "reaction", "kind": "emoji", "value": "👍"})
+				case 3:
+					g.send(map[string]interface{}{"type": "raise-hand"})
+				}
+			}
+		}(g, int64(100+i))
+	}
+	wg.Wait()
+
+	checkRoomInvariants(t)
+	if ids := family(); len(ids) != 1 {
+		t.Errorf("breakouts still open after close-breakouts: %v", ids[1:])
+	}
+}
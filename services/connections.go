@@ -0,0 +1,142 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// What to do when an init claims a user ID that is already connected.
+const (
+	duplicateReject   = "reject"
+	duplicateTakeover = "takeover"
+	duplicateMulti    = "multi"
+)
+
+// duplicateIDPolicy reads DUPLICATE_ID_POLICY:
+//   - "takeover" (default): a newcomer that proves the identity wins and
+//     the old connection is told `session-replaced` and closed; any other
+//     newcomer is rejected.
+//   - "reject": the newcomer gets an error and is closed.
+//   - "multi": every connection gets its own ID, "<userId>#<suffix>", so
+//     one person can join from several devices.
+//
+// A suspended connection holds its ID like a live one; its owner gets it
+// back with the resume token.
+func duplicateIDPolicy() string {
+	switch p := os.Getenv("DUPLICATE_ID_POLICY"); p {
+	case duplicateReject, duplicateMulti:
+		return p
+	default:
+		return duplicateTakeover
+	}
+}
+
+// verifiedAccount returns the username a JWT was issued to, or "" if the
+// token is missing or invalid.
+func verifiedAccount(token string) string {
+	if token == "" {
+		return ""
+	}
+	claims, err := ParseJWT(token)
+	if err != nil {
+		log.Printf("⚠️ Ignoring invalid auth token: %v", err)
+		return ""
+	}
+	return claims.Username
+}
+
+// provesIdentity reports whether a newcomer signed in as account may take
+// over existing. IDs are broadcast, so knowing one proves nothing, and
+// anyone can register a username equal to a guest's ID: only a JWT for the
+// account existing signed in with does. A guest is never taken over.
+func provesIdentity(existing *Client, account string) bool {
+	return account != "" && account == existing.account
+}
+
+// admitClient registers a fresh connection claiming userID, signed in as
+// account if it proved one, and sends its init reply through out. It
+// returns nil if the connection was refused.
+func admitClient(out *outbox, userID, account string, version int) *Client {
+	if userID == "" {
+		userID = uuid.New().String()
+	}
+
+	policy := duplicateIDPolicy()
+	for {
+		id := userID
+		if existing, taken := clients.get(userID); taken || policy == duplicateMulti {
+			switch {
+			case policy == duplicateMulti:
+				id = connectionID(userID)
+
+			case policy == duplicateTakeover && provesIdentity(existing, account):
+				log.Printf("🔁 New connection takes over %s", userID)
+				existing.send(&bareMessage{typed("session-replaced")})
+				removeClient(existing)
+
+			default:
+				log.Printf("⛔ Rejected duplicate connection for %s", userID)
+				// Let the refusal drain before the handler detaches the
+				// outbox.
+				out.push(duplicateIDError())
+				out.shutdown()
+				<-out.done
+				return nil
+			}
+		}
+
+		resumeToken := newResumeToken()
+		client := &Client{
+			ID:          id,
+			UserID:      userID,
+			account:     account,
+			Conn:        out.conn,
+			out:         out,
+			resumeToken: resumeToken,
+		}
+		out.bind(id)
+		// Another connection may have claimed the ID meanwhile; go round
+		// and apply the policy to it.
+		if !clients.add(client) {
+			continue
+		}
+		log.Println("🔌 Connected:", id)
+
+		client.send(&outInit{
+			messageType:     typed("init"),
+			UserID:          id,
+			Identity:        userID,
+			ResumeToken:     resumeToken,
+			DuplicatePolicy: policy,
+			Protocol:        version,
+		})
+		return client
+	}
+}
+
+// connectionID gives one of a user's connections its own ID.
+func connectionID(userID string) string {
+	b := make([]byte, 4)
+	for {
+		_, _ = rand.Read(b)
+		id := userID + "#" + hex.EncodeToString(b)
+		if _, taken := clients.get(id); !taken {
+			return id
+		}
+	}
+}
+
+// identitiesState maps each connection in the room to the user ID it
+// claimed, so clients can group a person's devices. Caller must hold the
+// room's lock.
+func identitiesState(room *Room) map[string]string {
+	identities := make(map[string]string, len(room.Clients))
+	for id, client := range room.Clients {
+		identities[id] = client.UserID
+	}
+	return identities
+}
@@ -0,0 +1,26 @@
+package services
+
+import "testing"
+
+func TestTakeoverNeedsSameAccount(t *testing.T) {
+	guest := connectPeer(t, "takeover-guest")
+
+	// A username equal to a guest's broadcast ID proves nothing.
+	if c := admitClient(newTestOutbox(&testPeer{t: t}), guest.client.ID, guest.client.ID, ProtocolVersion); c != nil {
+		removeClient(c)
+		t.Fatal("an account named after a guest took over its connection")
+	}
+
+	p := &testPeer{t: t}
+	p.client = admitClient(newTestOutbox(p), "takeover-member", "alice", ProtocolVersion)
+	t.Cleanup(func() { removeClient(p.client) })
+	if c := admitClient(newTestOutbox(&testPeer{t: t}), "takeover-member", "mallory", ProtocolVersion); c != nil {
+		removeClient(c)
+		t.Fatal("another account took over the connection")
+	}
+	c := admitClient(newTestOutbox(&testPeer{t: t}), "takeover-member", "alice", ProtocolVersion)
+	if c == nil {
+		t.Fatal("the same account could not take over its connection")
+	}
+	removeClient(c)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+const (
+	maxRoomTitleLength = 100
+	maxRoomTopicLength = 280
+	maxRoomTags        = 10
+	maxRoomTagLength   = 32
+)
+
+type DirectoryEntry struct {
+	RoomID           string   `json:"roomId"`
+	Title            string   `json:"title"`
+	Topic            string   `json:"topic"`
+	Tags             []string `json:"tags"`
+	ParticipantCount int      `json:"participantCount"`
+	ActiveVote       string   `json:"activeVote,omitempty"`
+}
+
+// loadRoomInfo fetches persisted metadata for a room, falling back to an
+// unlisted record when none exists yet.
+func loadRoomInfo(roomID string) models.Room {
+	info := models.Room{ID: roomID}
+	if config.DB != nil {
+		config.DB.Where("id = ?", roomID).Limit(1).Find(&info)
+	}
+	return info
+}
+
+// saveRoomInfo writes the editable columns, leaving created_at as first
+// saved.
+func saveRoomInfo(info models.Room) {
+	if config.DB == nil {
+		return
+	}
+	err := config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"host_id", "title", "topic", "tags", "listed", "updated_at"}),
+	}).Create(&info).Error
+	if err != nil {
+		log.Printf("❌ Failed to persist room info for %s: %v", info.ID, err)
+	}
+}
+
+func normalizeTags(raw []string) string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range raw {
+		tag = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(tag, ",", " ")))
+		if tag == "" || seen[tag] || utf8.RuneCountInString(tag) > maxRoomTagLength {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxRoomTags {
+			break
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+func truncateRunes(s string, max int) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+// updateRoomInfo applies a host's `update-room-info` message and persists
+// the result so title, topic and tags carry over to later sessions.
+func updateRoomInfo(client *Client, msg *roomInfoMessage) {
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	if room.HostID != client.ID || room.ParentID != "" {
+		room.mu.Unlock()
+		return
+	}
+
+	update := roomInfoOf(room.Info)
+	if msg.Title != nil {
+		update.Title = truncateRunes(*msg.Title, maxRoomTitleLength)
+	}
+	if msg.Topic != nil {
+		update.Topic = truncateRunes(*msg.Topic, maxRoomTopicLength)
+	}
+	if msg.Tags != nil {
+		update.Tags = normalizeTags(msg.Tags)
+	}
+	if msg.Listed != nil {
+		update.Listed = *msg.Listed
+	}
+	recordEvent(room, evRoomInfo, client.ID, update)
+
+	info := room.Info
+	entry, listed := directoryEntry(room)
+	broadcastRoomState(room.ID)
+	room.mu.Unlock()
+
+	saveRoomInfo(info)
+	publishListing(room.ID, entry, listed)
+}
+
+func roomInfoState(info models.Room) roomInfoView {
+	return roomInfoView{
+		Title:  info.Title,
+		Topic:  info.Topic,
+		Tags:   info.TagList(),
+		Listed: info.Listed,
+	}
+}
+
+// directoryEntry is the room's directory entry, if it is listed. Caller
+// must hold the room's lock.
+func directoryEntry(room *Room) (DirectoryEntry, bool) {
+	if !room.Info.Listed || room.ParentID != "" || len(room.Clients) == 0 {
+		return DirectoryEntry{}, false
+	}
+	return DirectoryEntry{
+		RoomID:           room.ID,
+		Title:            room.Info.Title,
+		Topic:            room.Info.Topic,
+		Tags:             room.Info.TagList(),
+		ParticipantCount: len(room.Clients),
+		ActiveVote:       room.ActiveVote,
+	}, true
+}
+
+// publishListing shares a room's entry with the other instances for as
+// long as its lease lasts, or withdraws it. Homes republish their rooms
+// whenever they renew the leases.
+func publishListing(roomID string, entry DirectoryEntry, listed bool) {
+	var raw []byte
+	if listed {
+		raw, _ = json.Marshal(entry)
+	}
+	if err := broker.ListRoom(roomID, raw, roomLeaseTTL()); err != nil {
+		log.Printf("❌ Failed to publish the listing of room %s: %v", roomID, err)
+	}
+}
+
+// ListedRooms returns live, listed rooms matching the optional free-text
+// query and tag, busiest first. Rooms homed here are read live; those
+// homed elsewhere come from their homes' listings, which lag by up to a
+// third of ROOM_LEASE_SECONDS.
+func ListedRooms(query, tag string) []DirectoryEntry {
+	query = strings.ToLower(strings.TrimSpace(query))
+	tag = strings.ToLower(strings.TrimSpace(tag))
+
+	entries := []DirectoryEntry{}
+	local := map[string]bool{}
+	for _, room := range rooms.all() {
+		room.mu.Lock()
+		local[room.ID] = true
+		if entry, listed := directoryEntry(room); listed {
+			entries = append(entries, entry)
+		}
+		room.mu.Unlock()
+	}
+
+	listings, err := broker.Listings()
+	if err != nil {
+		log.Printf("❌ Failed to read room listings: %v", err)
+	}
+	for _, raw := range listings {
+		var entry DirectoryEntry
+		if json.Unmarshal(raw, &entry) != nil || local[entry.RoomID] {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	matches := entries[:0]
+	for _, e := range entries {
+		if tag != "" && !containsString(e.Tags, tag) {
+			continue
+		}
+		if query != "" {
+			haystack := strings.ToLower(e.Title + " " + e.Topic + " " + strings.Join(e.Tags, " "))
+			if !strings.Contains(haystack, query) {
+				continue
+			}
+		}
+		matches = append(matches, e)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].ParticipantCount != matches[j].ParticipantCount {
+			return matches[i].ParticipantCount > matches[j].ParticipantCount
+		}
+		return matches[i].RoomID < matches[j].RoomID
+	})
+	return matches
+}
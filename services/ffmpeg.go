@@ -0,0 +1,16 @@
+package services
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ffmpegPath locates ffmpeg for server-side audio mixing. FFMPEG_PATH wins
+// over $PATH; features that need mixing degrade gracefully without it.
+func ffmpegPath() (string, bool) {
+	if p := os.Getenv("FFMPEG_PATH"); p != "" {
+		return p, true
+	}
+	p, err := exec.LookPath("ffmpeg")
+	return p, err == nil
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	floorTick = time.Second
+	// A speaker cut off by the per-turn limit may speak again after this.
+	turnMuteCooldown = 10 * time.Second
+	maxWarningLead   = 15 * time.Second
+)
+
+const (
+	muteTurn  = "turn-limit"
+	muteTotal = "total-limit"
+)
+
+// floorControl enforces host-set speaking budgets and runs go-around rounds.
+// Over-budget speakers are warned, then muted: the SFU stops forwarding
+// their audio and the speaker is told with `force-mute`. Guarded by
+// the room's lock like the rest of Room.
+type floorControl struct {
+	turnLimit  time.Duration
+	totalLimit time.Duration
+
+	muted      map[string]string
+	mutedUntil map[string]time.Time
+	warned     map[string]string
+
+	round *goAround
+	stop  chan struct{}
+}
+
+// goAround passes the floor through participants in order, each for a
+// fixed time; only the holder and the host may speak.
+type goAround struct {
+	order  []string
+	index  int
+	each   time.Duration
+	endsAt time.Time
+}
+
+func newFloorControl() *floorControl {
+	return &floorControl{
+		muted:      make(map[string]string),
+		mutedUntil: make(map[string]time.Time),
+		warned:     make(map[string]string),
+	}
+}
+
+// reset ends the meeting's round and mutes; the host's limits stay.
+func (f *floorControl) reset() {
+	f.round = nil
+	f.muted = make(map[string]string)
+	f.mutedUntil = make(map[string]time.Time)
+	f.warned = make(map[string]string)
+}
+
+func (f *floorControl) holder() string {
+	if f.round == nil || f.round.index >= len(f.round.order) {
+		return ""
+	}
+	return f.round.order[f.round.index]
+}
+
+func (f *floorControl) mutedSet() map[string]bool {
+	if len(f.muted) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(f.muted))
+	for id := range f.muted {
+		set[id] = true
+	}
+	return set
+}
+
+func (f *floorControl) active() bool {
+	return f.turnLimit > 0 || f.totalLimit > 0 || f.round != nil || len(f.mutedUntil) > 0
+}
+
+// ensureFloorTicker starts or stops the room's enforcement loop to match the
+// configuration. Caller must hold the room's lock.
+func ensureFloorTicker(room *Room) {
+	f := room.Floor
+	running := f.stop != nil
+	wanted := f.active() && len(room.Clients) > 0
+
+	switch {
+	case wanted && !running:
+		f.stop = make(chan struct{})
+		go runFloorTicker(room.ID, f, f.stop)
+	case !wanted && running:
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+func runFloorTicker(roomID string, f *floorControl, stop chan struct{}) {
+	ticker := time.NewTicker(floorTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if room, exists := lockRoom(roomID); exists {
+				if room.Floor == f {
+					enforceFloor(room, now)
+				}
+				room.mu.Unlock()
+			}
+		}
+	}
+}
+
+// enforceFloor runs once per tick. Caller must hold the room's lock.
+func enforceFloor(room *Room, now time.Time) {
+	f := room.Floor
+	changed := false
+
+	for id, until := range f.mutedUntil {
+		if now.After(until) {
+			delete(f.mutedUntil, id)
+			if f.muted[id] == muteTurn {
+				unmuteClient(room, id)
+				changed = true
+			}
+		}
+	}
+
+	for id, client := range room.Clients {
+		if id == room.HostID || f.muted[id] != "" {
+			continue
+		}
+		speaking, turn, total := room.Activity.current(id, now)
+		if !speaking {
+			continue
+		}
+
+		switch {
+		case f.totalLimit > 0 && total >= f.totalLimit:
+			muteClient(room, client, muteTotal, time.Time{})
+			changed = true
+		case f.turnLimit > 0 && turn >= f.turnLimit:
+			muteClient(room, client, muteTurn, now.Add(turnMuteCooldown))
+			changed = true
+		case f.totalLimit > 0 && total >= f.totalLimit-warningLead(f.totalLimit):
+			warnSpeaker(room, client, muteTotal, f.totalLimit-total)
+		case f.turnLimit > 0 && turn >= f.turnLimit-warningLead(f.turnLimit):
+			// Key the warning by turn so each new turn is warned again.
+			turnStart := now.Add(-turn).UnixMilli()
+			warnSpeaker(room, client, fmt.Sprintf("%s@%d", muteTurn, turnStart), f.turnLimit-turn)
+		}
+	}
+
+	if f.round != nil && now.After(f.round.endsAt) {
+		advanceFloor(room, now)
+		changed = true
+	}
+
+	if changed {
+		syncPublishers(room)
+		broadcastRoomState(room.ID)
+	}
+	ensureFloorTicker(room)
+}
+
+// warningLead leaves at least two ticks between warning and mute.
+func warningLead(limit time.Duration) time.Duration {
+	lead := limit / 4
+	if lead < 2*floorTick {
+		lead = 2 * floorTick
+	}
+	if lead > maxWarningLead {
+		lead = maxWarningLead
+	}
+	return lead
+}
+
+// warnSpeaker sends one warning per key. Caller must hold the room's lock.
+func warnSpeaker(room *Room, client *Client, key string, remaining time.Duration) {
+	f := room.Floor
+	if f.warned[client.ID] == key {
+		return
+	}
+	f.warned[client.ID] = key
+
+	client.send(&outSpeakingWarning{
+		messageType: typed("speaking-warning"),
+		Limit:       strings.SplitN(key, "@", 2)[0],
+		RemainingMs: remaining.Milliseconds(),
+	})
+}
+
+// muteClient mutes a speaker server-side. A zero until means the mute lasts
+// until the host lifts it. Caller must hold the room's lock.
+func muteClient(room *Room, client *Client, reason string, until time.Time) {
+	f := room.Floor
+	f.muted[client.ID] = reason
+	if !until.IsZero() {
+		f.mutedUntil[client.ID] = until
+	}
+
+	log.Printf("🔇 Muted %s in room %s (%s)", client.ID, room.ID, reason)
+	msg := &outForceMute{messageType: typed("force-mute"), Reason: reason}
+	if !until.IsZero() {
+		msg.Until = until.UnixMilli()
+	}
+	client.send(msg)
+}
+
+// unmuteClient lifts a server mute. Caller must hold the room's lock.
+func unmuteClient(room *Room, clientID string) {
+	f := room.Floor
+	if _, ok := f.muted[clientID]; !ok {
+		return
+	}
+	delete(f.muted, clientID)
+	delete(f.mutedUntil, clientID)
+
+	if client, ok := room.Clients[clientID]; ok {
+		client.send(&bareMessage{typed("unmute")})
+	}
+}
+
+func setSpeakingLimits(host *Client, turnSeconds, totalSeconds float64) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || turnSeconds < 0 || totalSeconds < 0 {
+		return
+	}
+
+	room.Floor.turnLimit = time.Duration(turnSeconds * float64(time.Second))
+	room.Floor.totalLimit = time.Duration(totalSeconds * float64(time.Second))
+	room.Floor.warned = make(map[string]string)
+
+	log.Printf("⏱️ Speaking limits in room %s: %v per turn, %v in total", room.ID, room.Floor.turnLimit, room.Floor.totalLimit)
+	ensureFloorTicker(room)
+	broadcastRoomState(room.ID)
+}
+
+// liftMute lets the host unmute anyone muted by the server.
+func liftMute(host *Client, userID string) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+
+	unmuteClient(room, userID)
+	syncPublishers(room)
+	broadcastRoomState(room.ID)
+}
+
+// startGoAround gives each participant the floor in turn. Without an
+// explicit order, participants go in ID order with the host last.
+func startGoAround(host *Client, order []string, seconds float64) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || seconds <= 0 {
+		return
+	}
+
+	ids := []string{}
+	for _, id := range order {
+		if _, present := room.Clients[id]; present && !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(order) == 0 {
+		for id := range room.Clients {
+			if id != room.HostID {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		ids = append(ids, room.HostID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	room.Floor.round = &goAround{
+		order: ids,
+		index: -1,
+		each:  time.Duration(seconds * float64(time.Second)),
+	}
+	log.Printf("🔁 Go-around started in room %s with %d speakers", room.ID, len(ids))
+	advanceFloor(room, time.Now())
+	syncPublishers(room)
+	broadcastRoomState(room.ID)
+	ensureFloorTicker(room)
+}
+
+// nextSpeaker passes the floor on; the holder may yield early and the host
+// may skip.
+func nextSpeaker(client *Client) {
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.Floor.round == nil {
+		return
+	}
+	if client.ID != room.HostID && client.ID != room.Floor.holder() {
+		return
+	}
+
+	advanceFloor(room, time.Now())
+	syncPublishers(room)
+	broadcastRoomState(room.ID)
+	ensureFloorTicker(room)
+}
+
+func stopGoAround(host *Client) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || room.Floor.round == nil {
+		return
+	}
+
+	endGoAround(room)
+	syncPublishers(room)
+	broadcastRoomState(room.ID)
+	ensureFloorTicker(room)
+}
+
+// advanceFloor hands the floor to the next participant still present, or
+// ends the round. Caller must hold the room's lock.
+func advanceFloor(room *Room, now time.Time) {
+	r := room.Floor.round
+	for r.index++; r.index < len(r.order); r.index++ {
+		if _, present := room.Clients[r.order[r.index]]; present {
+			break
+		}
+	}
+	if r.index >= len(r.order) {
+		endGoAround(room)
+		return
+	}
+
+	r.endsAt = now.Add(r.each)
+	broadcastMessage(room.ID, &outFloor{
+		messageType: typed("floor"),
+		UserID:      r.order[r.index],
+		EndsAt:      r.endsAt.UnixMilli(),
+		Position:    r.index + 1,
+		Total:       len(r.order),
+	})
+}
+
+// endGoAround closes the round. Caller must hold the room's lock.
+func endGoAround(room *Room) {
+	room.Floor.round = nil
+	log.Printf("🔁 Go-around ended in room %s", room.ID)
+	broadcastMessage(room.ID, &bareMessage{typed("go-around-ended")})
+}
+
+func floorState(room *Room) floorView {
+	f := room.Floor
+	muted := map[string]string{}
+	for id, reason := range f.muted {
+		muted[id] = reason
+	}
+
+	state := floorView{
+		TurnLimitSeconds:  f.turnLimit.Seconds(),
+		TotalLimitSeconds: f.totalLimit.Seconds(),
+		Muted:             muted,
+	}
+	if r := f.round; r != nil {
+		state.GoAround = &goAroundView{
+			Order:   r.order,
+			Holder:  f.holder(),
+			EndsAt:  r.endsAt.UnixMilli(),
+			Seconds: r.each.Seconds(),
+		}
+	}
+	return state
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+const (
+	maxGalleryItems   = 200
+	maxCaptionLength  = 280
+	maxMediaURLLength = 2048
+)
+
+// galleryWindow is how far back an opening room reaches for its gallery.
+func galleryWindow() time.Duration {
+	return time.Duration(envInt("GALLERY_WINDOW_HOURS", 24)) * time.Hour
+}
+
+func loadGallery(roomID string) []models.GalleryItem {
+	items := []models.GalleryItem{}
+	if config.DB != nil {
+		config.DB.Where("room_id = ?", roomID).Order("shared_at").Find(&items)
+	}
+	return items
+}
+
+// recentGallery is what an opening room starts with: the latest items
+// shared within GALLERY_WINDOW_HOURS, so a reused room ID does not bring
+// back every earlier session's media.
+func recentGallery(roomID string) []models.GalleryItem {
+	items := []models.GalleryItem{}
+	if config.DB != nil {
+		config.DB.Where("room_id = ? AND shared_at >= ?", roomID, time.Now().UTC().Add(-galleryWindow())).
+			Order("shared_at desc").Limit(maxGalleryItems).Find(&items)
+	}
+	slices.Reverse(items)
+	return items
+}
+
+// sendGallery gives a client entering a room the whole gallery; changes
+// after that arrive as gallery-item and gallery-item-removed. Caller must
+// hold the room's lock.
+func sendGallery(room *Room, client *Client) {
+	client.send(&outGallery{
+		messageType: typed("gallery"),
+		RoomID:      room.ID,
+		Items:       galleryState(room.Gallery),
+	})
+}
+
+// RoomGallery returns what was shared in the session a room token grants,
+// with fresh download links.
+func RoomGallery(grant RoomGrant) []SharedMedia {
+	items := []models.GalleryItem{}
+	for _, item := range loadGallery(grant.RoomID) {
+		if grant.Covers(item.SharedAt) {
+			items = append(items, item)
+		}
+	}
+	return galleryState(items)
+}
+
+// shareMessage turns a `share-media` message into a gallery item: either an
+// uploaded file by mediaId or an external url with its mediaType.
+func shareMessage(client *Client, msg *shareMediaMessage) {
+	item := models.GalleryItem{Caption: truncateRunes(msg.Caption, maxCaptionLength)}
+
+	if mediaID := msg.MediaID; mediaID != "" {
+		var media models.Media
+		if !validMediaID(mediaID) || config.DB == nil ||
+			config.DB.Where("id = ?", mediaID).Limit(1).Find(&media).RowsAffected == 0 {
+			sendError(client, "Unknown media")
+			return
+		}
+		item.MediaID = media.ID
+		item.MediaType = media.ContentType
+		item.Filename = media.Filename
+	} else {
+		item.URL = msg.URL
+		item.MediaType = normalizeMediaType(msg.MediaType)
+	}
+
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+	if len(room.Gallery) >= maxGalleryItems {
+		sendError(client, "The gallery is full; remove an item first")
+		return
+	}
+
+	item.ID = uuid.New().String()
+	item.RoomID = room.ID
+	item.SharedBy = client.ID
+	item.SharedAt = time.Now().UTC()
+	recordEvent(room, evMediaShared, client.ID, item)
+	saveGalleryItem(item)
+	room.Minutes.shared(item)
+
+	broadcastMessage(room.ID, &outGalleryItem{messageType: typed("gallery-item"), Item: galleryItemState(item)})
+	// sharedMedia in room-state is the latest item.
+	broadcastRoomState(room.ID)
+}
+
+// Clients sharing by URL name the kind of media rather than its content
+// type; PDFs are stored under their content type so they can be presented.
+var shortMediaTypes = map[string]string{"pdf": "application/pdf"}
+
+func normalizeMediaType(mediaType string) string {
+	if full, ok := shortMediaTypes[strings.ToLower(mediaType)]; ok {
+		return full
+	}
+	return mediaType
+}
+
+// pinGalleryItem and removeGalleryItem are host-only curation.
+func pinGalleryItem(host *Client, itemID string, pinned bool) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+	for i := range room.Gallery {
+		if room.Gallery[i].ID == itemID {
+			recordEvent(room, evMediaPinned, host.ID, mediaPinnedEvent{ItemID: itemID, Pinned: pinned})
+			saveGalleryItem(room.Gallery[i])
+			broadcastMessage(room.ID, &outGalleryItem{messageType: typed("gallery-item"), Item: galleryItemState(room.Gallery[i])})
+			if i == len(room.Gallery)-1 {
+				broadcastRoomState(room.ID)
+			}
+			return
+		}
+	}
+}
+
+func removeGalleryItem(host *Client, itemID string) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+	for i, item := range room.Gallery {
+		if item.ID == itemID {
+			latest := i == len(room.Gallery)-1
+			recordEvent(room, evMediaRemoved, host.ID, map[string]interface{}{"itemId": itemID})
+			if config.DB != nil {
+				config.DB.Delete(&item)
+			}
+			broadcastMessage(room.ID, &itemMessage{messageType: typed("gallery-item-removed"), ItemID: itemID})
+			if latest {
+				broadcastRoomState(room.ID)
+			}
+			return
+		}
+	}
+}
+
+// clearSharedMedia takes the latest item off everyone's screen without
+// removing it from the gallery. It is host-only, like curation.
+func clearSharedMedia(host *Client) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || room.MediaCleared || len(room.Gallery) == 0 {
+		return
+	}
+	recordEvent(room, evMediaCleared, host.ID, nil)
+	broadcastRoomState(room.ID)
+}
+
+func saveGalleryItem(item models.GalleryItem) {
+	if config.DB == nil {
+		return
+	}
+	if err := config.DB.Save(&item).Error; err != nil {
+		log.Printf("❌ Failed to persist gallery item for %s: %v", item.RoomID, err)
+	}
+}
+
+// galleryItemState re-signs uploaded media every time it is sent so late
+// joiners never receive an expired link.
+func galleryItemState(item models.GalleryItem) SharedMedia {
+	state := SharedMedia{
+		Type:      "shared-media",
+		ItemID:    item.ID,
+		UserID:    item.SharedBy,
+		URL:       item.URL,
+		MediaType: item.MediaType,
+		Caption:   item.Caption,
+		Pinned:    item.Pinned,
+		SharedAt:  item.SharedAt.UnixMilli(),
+	}
+	if item.MediaID != "" {
+		state.MediaID = item.MediaID
+		state.Filename = item.Filename
+		state.URL = MediaURL(item.MediaID)
+		if strings.HasPrefix(item.MediaType, "image/") {
+			state.ThumbnailURL = MediaThumbnailURL(item.MediaID)
+		}
+	}
+	return state
+}
+
+func galleryState(items []models.GalleryItem) []SharedMedia {
+	out := make([]SharedMedia, 0, len(items))
+	for _, item := range items {
+		out = append(out, galleryItemState(item))
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package services
+
+import "testing"
+
+func TestClearMediaHidesItForTheRoom(t *testing.T) {
+	host := connectPeer(t, "clear-host")
+	guest := connectPeer(t, "clear-guest")
+	host.join("clear-room", true)
+	guest.join("clear-room", false)
+
+	shown := func(p *testPeer) func() bool {
+		return func() bool {
+			state := p.latest("room-state")
+			return state != nil && state["sharedMedia"] != nil
+		}
+	}
+	hidden := func(p *testPeer) func() bool {
+		return func() bool {
+			state := p.latest("room-state")
+			return state != nil && state["sharedMedia"] == nil
+		}
+	}
+
+	host.send(map[string]interface{}{"type": "share-media", "url": "https://example.com/a.png", "mediaType": "image"})
+	waitUntil(t, "the guest sees the shared media", shown(guest))
+
+	// Only the host may clear it.
+	room, _ := rooms.get("clear-room")
+	guest.send(map[string]interface{}{"type": "clear-media"})
+	room.mu.Lock()
+	cleared, shared := room.MediaCleared, len(room.Gallery)
+	room.mu.Unlock()
+	if cleared {
+		t.Fatal("a guest cleared the shared media")
+	}
+
+	host.send(map[string]interface{}{"type": "clear-media"})
+	waitUntil(t, "the guest sees the media cleared", hidden(guest))
+	room.mu.Lock()
+	items := len(room.Gallery)
+	room.mu.Unlock()
+	if items != shared {
+		t.Fatalf("clearing left %d gallery items, want %d", items, shared)
+	}
+
+	// The next share shows again.
+	guest.send(map[string]interface{}{"type": "share-media", "url": "https://example.com/b.png", "mediaType": "image"})
+	waitUntil(t, "the host sees the next share", shown(host))
+}
+
+func TestSharedPDFCanBePresented(t *testing.T) {
+	host := connectPeer(t, "pdf-host")
+	host.join("pdf-room", true)
+	host.send(map[string]interface{}{"type": "share-media", "url": "https://example.com/deck.pdf", "mediaType": "pdf"})
+
+	room, _ := rooms.get("pdf-room")
+	room.mu.Lock()
+	itemID := room.Gallery[0].ID
+	mediaType := room.Gallery[0].MediaType
+	room.mu.Unlock()
+	if mediaType != "application/pdf" {
+		t.Fatalf("a shared pdf was stored as %q", mediaType)
+	}
+
+	host.send(map[string]interface{}{"type": "present-page", "documentId": itemID, "page": 1})
+	room.mu.Lock()
+	presenting := room.Presentation != nil && room.Presentation.DocumentID == itemID
+	room.mu.Unlock()
+	if !presenting {
+		t.Fatal("a shared pdf could not be presented")
+	}
+}
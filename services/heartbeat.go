@@ -0,0 +1,75 @@
+package services
+
+import (
+	"strconv"
+	"time"
+
+	ws "github.com/gofiber/websocket/v2"
+)
+
+const heartbeatWriteWait = 5 * time.Second
+
+// The server pings every WS_PING_INTERVAL seconds (default 20). A socket
+// that sends nothing, not even a pong, for WS_PONG_TIMEOUT seconds
+// (default 45) is treated as dropped.
+func pingInterval() time.Duration { return envSeconds("WS_PING_INTERVAL", 20*time.Second) }
+
+func pongTimeout() time.Duration {
+	timeout := envSeconds("WS_PONG_TIMEOUT", 45*time.Second)
+	if floor := pingInterval() + time.Second; timeout < floor {
+		timeout = floor
+	}
+	return timeout
+}
+
+// startHeartbeat pings conn until stop is closed and arms its read
+// deadline; every pong or message pushes the deadline back. onPong
+// receives each ping's round trip. Call it from the connection's reader
+// before the read loop, and extendDeadline after each message. The returned
+// channel closes once the pinger has stopped; wait for it before the
+// handler returns, since the connection is then reused.
+func startHeartbeat(conn *ws.Conn, onPong func(rtt time.Duration), stop <-chan struct{}) <-chan struct{} {
+	timeout := pongTimeout()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil && onPong != nil {
+			onPong(time.Since(time.Unix(0, sent)))
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pingInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// WriteControl may run alongside other writers.
+				payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+				if err := conn.WriteControl(ws.PingMessage, payload, time.Now().Add(heartbeatWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return done
+}
+
+func extendDeadline(conn *ws.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(pongTimeout()))
+}
+
+// latencyState reports each connection's last ping round trip in ms; 0
+// means no pong yet.
+func latencyState(room *Room) map[string]int64 {
+	latency := make(map[string]int64, len(room.Clients))
+	for id, client := range room.Clients {
+		latency[id] = client.latency.Load()
+	}
+	return latency
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxImagePixels = 40_000_000
+	thumbnailSize  = 320
+)
+
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+func maxImageDimension() int { return envInt("MEDIA_MAX_DIMENSION", 2560) }
+
+// processedImage is an upload after re-encoding. Decoding and encoding
+// again drops EXIF, XMP, comments and any trailing data, so nothing the
+// camera embedded (GPS, serial numbers) survives.
+type processedImage struct {
+	Data          []byte
+	ContentType   string
+	Width, Height int
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// processImage strips metadata from an uploaded image, bakes in its EXIF
+// orientation, caps its size and renders a thumbnail.
+func processImage(raw []byte, contentType string) (processedImage, error) {
+	var out processedImage
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return out, ErrMediaType
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return out, ErrImageTooLarge
+	}
+
+	if contentType == "image/gif" {
+		return processGIF(raw)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return out, ErrMediaType
+	}
+	// Downscale first so orienting never walks more pixels than are kept.
+	img = fitImage(img, maxImageDimension())
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(raw))
+	}
+
+	// There is no pure-Go WebP encoder; WebP is stored as PNG.
+	encode := encodePNG
+	out.ContentType = "image/png"
+	if contentType == "image/jpeg" {
+		encode = encodeJPEG
+		out.ContentType = "image/jpeg"
+	}
+
+	if out.Data, err = encode(img); err != nil {
+		return out, err
+	}
+	if out.Thumbnail, err = encode(fitImage(img, thumbnailSize)); err != nil {
+		return out, err
+	}
+	out.ThumbnailType = out.ContentType
+	out.Width, out.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	return out, nil
+}
+
+// processGIF keeps animation frames but drops comment and application
+// extensions. Animated GIFs are not resized; the thumbnail is the first
+// frame.
+func processGIF(raw []byte) (processedImage, error) {
+	var out processedImage
+
+	// Every frame is decoded into its own buffer, so a small file of many
+	// frames is as costly as a huge image. Count before decoding.
+	cfg, err := gif.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return out, ErrMediaType
+	}
+	frames, ok := gifFrameCount(raw)
+	if !ok {
+		return out, ErrMediaType
+	}
+	if cfg.Width*cfg.Height*frames > maxImagePixels {
+		return out, ErrImageTooLarge
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(raw))
+	if err != nil || len(g.Image) == 0 {
+		return out, ErrMediaType
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return out, err
+	}
+
+	first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(first, first.Bounds(), g.Image[0], image.Point{}, draw.Over)
+	thumb, err := encodePNG(fitImage(first, thumbnailSize))
+	if err != nil {
+		return out, err
+	}
+
+	return processedImage{
+		Data:          buf.Bytes(),
+		ContentType:   "image/gif",
+		Width:         g.Config.Width,
+		Height:        g.Config.Height,
+		Thumbnail:     thumb,
+		ThumbnailType: "image/png",
+	}, nil
+}
+
+// gifFrameCount counts the image descriptors in a GIF by walking its
+// blocks without decompressing them.
+func gifFrameCount(raw []byte) (int, bool) {
+	if len(raw) < 13 || string(raw[:3]) != "GIF" {
+		return 0, false
+	}
+	i := 13
+	if flags := raw[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1) // global color table
+	}
+
+	// skipSubBlocks returns the index after a run of data sub-blocks.
+	skipSubBlocks := func(i int) int {
+		for i < len(raw) && raw[i] != 0 {
+			i += int(raw[i]) + 1
+		}
+		return i + 1
+	}
+
+	frames := 0
+	for i < len(raw) {
+		switch raw[i] {
+		case 0x21: // extension: label, then sub-blocks
+			i = skipSubBlocks(i + 2)
+		case 0x2C: // image descriptor, color table, LZW code size, data
+			if i+10 > len(raw) {
+				return 0, false
+			}
+			flags := raw[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i = skipSubBlocks(i + 1)
+			frames++
+		case 0x3B: // trailer
+			return frames, true
+		default:
+			return 0, false
+		}
+	}
+	// A truncated file is left for the decoder to reject.
+	return frames, true
+}
+
+// fitImage scales img down so neither side exceeds max.
+func fitImage(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// fillImage crops img to a centered square and scales it to size, for
+// avatars.
+func fillImage(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		b.Min.X+(b.Dx()-side)/2,
+		b.Min.Y+(b.Dy()-side)/2,
+	))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, flattenImage(img), &jpeg.Options{Quality: 85})
+	return buf.Bytes(), err
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// flattenImage puts img on white, since JPEG has no alpha.
+func flattenImage(img image.Image) image.Image {
+	if _, opaque := img.(*image.YCbCr); opaque {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) from a JPEG, or 1
+// when there is none.
+func jpegOrientation(raw []byte) int {
+	if len(raw) < 4 || raw[0] != 0xFF || raw[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(raw); {
+		if raw[i] != 0xFF {
+			return 1
+		}
+		marker := raw[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1 // start of scan: no EXIF before the image data
+		}
+		length := int(binary.BigEndian.Uint16(raw[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(raw) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if o := exifOrientation(raw[i+4 : end]); o != 0 {
+				return o
+			}
+		}
+		i = end
+	}
+	return 1
+}
+
+func exifOrientation(seg []byte) int {
+	if len(seg) < 14 || string(seg[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := seg[6:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// applyOrientation rotates and flips img so it displays upright once the
+// orientation tag is gone.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// processAvatar turns an uploaded picture into a square avatar and a
+// smaller thumbnail, both PNG and free of metadata.
+func processAvatar(raw []byte) (avatar, thumb []byte, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, ErrMediaType
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, ErrMediaType
+	}
+	// The centered square crop commutes with every orientation, so it is
+	// oriented after scaling down.
+	square := fillImage(img, 256)
+	if format == "jpeg" {
+		square = applyOrientation(square, jpegOrientation(raw))
+	}
+
+	if avatar, err = encodePNG(square); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	if thumb, err = encodePNG(fillImage(square, 64)); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return avatar, thumb, nil
+}
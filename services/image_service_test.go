@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeTestGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	g := &gif.GIF{Config: image.Config{Width: width, Height: height, ColorModel: color.Palette(palette.Plan9)}}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFramesCountTowardsPixelLimit(t *testing.T) {
+	raw := encodeTestGIF(t, 4000, 4000, 3)
+	if frames, ok := gifFrameCount(raw); !ok || frames != 3 {
+		t.Fatalf("counted %d frames (ok %v), want 3", frames, ok)
+	}
+	if _, err := processImage(raw, "image/gif"); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("a 3-frame 4000x4000 GIF gave %v, want ErrImageTooLarge", err)
+	}
+
+	out, err := processImage(encodeTestGIF(t, 100, 100, 5), "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Width != 100 || out.Height != 100 {
+		t.Fatalf("GIF came out %dx%d", out.Width, out.Height)
+	}
+}
+
+// withOrientation inserts an EXIF segment with the orientation tag right
+// after a JPEG's start-of-image marker.
+func withOrientation(raw []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	seg := append(append([]byte("Exif\x00\x00"), tiff...), entry...)
+
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	out := append([]byte{}, raw[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, raw[2:]...)
+}
+
+func TestOrientedAfterDownscaling(t *testing.T) {
+	t.Setenv("MEDIA_MAX_DIMENSION", "100")
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Orientation 6 is a quarter turn: the landscape source is portrait.
+	out, err := processImage(withOrientation(buf.Bytes(), 6), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Width != 50 || out.Height != 100 {
+		t.Fatalf("image came out %dx%d, want 50x100", out.Width, out.Height)
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+const (
+	livestreamPlaylist = "index.m3u8"
+	// Browsers send 20ms Opus frames; the mixer paces itself to match.
+	livestreamFrame    = 20 * time.Millisecond
+	livestreamFrameTS  = 960
+	livestreamIdle     = 2 * time.Second
+	livestreamCleanup  = time.Minute
+	livestreamQueueLen = 25
+)
+
+// opusSilence is a single 20ms Opus frame of silence.
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+func livestreamDir() string {
+	if dir := os.Getenv("LIVESTREAM_DIR"); dir != "" {
+		return dir
+	}
+	return "./livestreams"
+}
+
+func livestreamSlots() int { return envInt("LIVESTREAM_MIX_SLOTS", 8) }
+
+// LivestreamDir is where a stream's playlist and segments are written.
+func LivestreamDir(streamID string) string {
+	return filepath.Join(livestreamDir(), streamID)
+}
+
+// livestreamSlot is one ffmpeg mixer input. A forwarded track is bound to a
+// free slot on its first packet and released once it goes quiet.
+type livestreamSlot struct {
+	trackID  string
+	lastSeen time.Time
+	frames   chan []byte
+	out      *oggwriter.OggWriter
+	pipe     *os.File
+	seq      uint16
+	ts       uint32
+}
+
+// roomLivestream mixes an SFU room's audio with ffmpeg into an HLS playlist.
+// Every slot receives a frame each tick, silence when idle, so ffmpeg's
+// amix never stalls waiting for a quiet input.
+type roomLivestream struct {
+	id        string
+	roomID    string
+	dir       string
+	startedAt time.Time
+	cmd       *exec.Cmd
+
+	mu      sync.Mutex
+	slots   []*livestreamSlot
+	byTrack map[string]*livestreamSlot
+	stopped bool
+	done    chan struct{}
+}
+
+func newRoomLivestream(roomID string) (*roomLivestream, error) {
+	ffmpeg, ok := ffmpegPath()
+	if !ok {
+		return nil, fmt.Errorf("ffmpeg not available")
+	}
+
+	id := uuid.New().String()
+	dir := LivestreamDir(id)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create livestream directory: %w", err)
+	}
+
+	ls := &roomLivestream{
+		id:        id,
+		roomID:    roomID,
+		dir:       dir,
+		startedAt: time.Now(),
+		byTrack:   make(map[string]*livestreamSlot),
+		done:      make(chan struct{}),
+	}
+
+	n := livestreamSlots()
+	if n < 1 {
+		n = 1
+	}
+
+	// Each slot is an Ogg/Opus pipe handed to ffmpeg as fd 3, 4, ...
+	args := []string{"-loglevel", "error"}
+	var readers []*os.File
+	for i := 0; i < n; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeFiles(readers)
+			ls.closeSlots()
+			return nil, fmt.Errorf("failed to create mixer pipe: %w", err)
+		}
+		readers = append(readers, r)
+		ls.slots = append(ls.slots, &livestreamSlot{frames: make(chan []byte, livestreamQueueLen), pipe: w})
+		args = append(args, "-f", "ogg", "-i", fmt.Sprintf("pipe:%d", 3+i))
+	}
+	args = append(args,
+		"-filter_complex", fmt.Sprintf("amix=inputs=%d:normalize=0", n),
+		"-c:a", "aac", "-b:a", "128k",
+		"-f", "hls",
+		"-hls_time", "2",
+		"-hls_list_size", "6",
+		"-hls_flags", "delete_segments",
+		"-hls_segment_filename", filepath.Join(dir, "segment%d.ts"),
+		filepath.Join(dir, livestreamPlaylist),
+	)
+
+	ls.cmd = exec.Command(ffmpeg, args...)
+	ls.cmd.ExtraFiles = readers
+	if err := ls.cmd.Start(); err != nil {
+		closeFiles(readers)
+		ls.closeSlots()
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	// ffmpeg holds its own copies of the read ends now.
+	closeFiles(readers)
+
+	go ls.pace()
+	go ls.wait()
+	return ls, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// write queues a forwarded packet's Opus frame for the track's slot.
+func (ls *roomLivestream) write(trackID string, pkt *rtp.Packet) {
+	if len(pkt.Payload) == 0 {
+		return
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.stopped {
+		return
+	}
+
+	slot, ok := ls.byTrack[trackID]
+	if !ok {
+		for _, s := range ls.slots {
+			if s.trackID == "" {
+				slot = s
+				break
+			}
+		}
+		if slot == nil {
+			return
+		}
+		slot.trackID = trackID
+		ls.byTrack[trackID] = slot
+	}
+	slot.lastSeen = time.Now()
+
+	frame := append([]byte(nil), pkt.Payload...)
+	select {
+	case slot.frames <- frame:
+	default:
+		// ffmpeg fell behind; drop rather than block the forwarder.
+	}
+}
+
+// pace feeds every slot one frame per tick until the stream stops.
+func (ls *roomLivestream) pace() {
+	ticker := time.NewTicker(livestreamFrame)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ls.done:
+			return
+		case <-ticker.C:
+		}
+
+		ls.mu.Lock()
+		for _, s := range ls.slots {
+			if s.trackID != "" && time.Since(s.lastSeen) > livestreamIdle {
+				delete(ls.byTrack, s.trackID)
+				s.trackID = ""
+			}
+		}
+		ls.mu.Unlock()
+
+		for _, s := range ls.slots {
+			frame := opusSilence
+			select {
+			case frame = <-s.frames:
+			default:
+			}
+			if err := s.writeFrame(frame); err != nil {
+				log.Printf("⚠️ Livestream %s mixer input failed: %v", ls.id, err)
+				ls.stop()
+				return
+			}
+		}
+	}
+}
+
+func (s *livestreamSlot) writeFrame(frame []byte) error {
+	if s.out == nil {
+		out, err := oggwriter.NewWith(s.pipe, 48000, 2)
+		if err != nil {
+			return err
+		}
+		s.out = out
+	}
+	s.seq++
+	s.ts += livestreamFrameTS
+	return s.out.WriteRTP(&rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: s.seq, Timestamp: s.ts},
+		Payload: frame,
+	})
+}
+
+// wait reaps ffmpeg and takes the stream off the room if it exits on its
+// own.
+func (ls *roomLivestream) wait() {
+	err := ls.cmd.Wait()
+	ls.stop()
+
+	if room, exists := lockRoom(ls.roomID); exists {
+		if room.livestream == ls {
+			log.Printf("⚠️ Livestream %s ended unexpectedly: %v", ls.id, err)
+			room.livestream = nil
+			if room.sfu != nil {
+				room.sfu.livestream.Store(nil)
+			}
+			broadcastRoomState(ls.roomID)
+		}
+		room.mu.Unlock()
+	}
+
+	time.AfterFunc(livestreamCleanup, func() {
+		_ = os.RemoveAll(ls.dir)
+	})
+}
+
+// stop closes the mixer inputs and asks ffmpeg to finish the playlist.
+func (ls *roomLivestream) stop() {
+	ls.mu.Lock()
+	if ls.stopped {
+		ls.mu.Unlock()
+		return
+	}
+	ls.stopped = true
+	close(ls.done)
+	ls.mu.Unlock()
+
+	ls.closeSlots()
+	if ls.cmd != nil && ls.cmd.Process != nil {
+		_ = ls.cmd.Process.Signal(syscall.SIGINT)
+	}
+}
+
+func (ls *roomLivestream) closeSlots() {
+	for _, s := range ls.slots {
+		_ = s.pipe.Close()
+	}
+}
+
+// startLivestream begins the public HLS stream on the host's request. Like
+// recording, it needs the room's media on the SFU.
+func startLivestream(host *Client) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || room.livestream != nil {
+		return
+	}
+
+	session := roomSFU(room, room.ID)
+	if session == nil || room.Topology != topologySFU {
+		sendError(host, "Livestreaming requires the room to use SFU topology")
+		return
+	}
+
+	ls, err := newRoomLivestream(room.ID)
+	if err != nil {
+		log.Printf("❌ Livestream for room %s: %v", room.ID, err)
+		sendError(host, "Could not start livestream")
+		return
+	}
+
+	room.livestream = ls
+	session.livestream.Store(ls)
+
+	log.Printf("📡 Livestream %s started in room %s", ls.id, room.ID)
+	broadcastRoomState(room.ID)
+}
+
+// stopLivestream ends the room's livestream, if any. Caller must hold
+// the room's lock.
+func stopLivestream(room *Room) {
+	ls := room.livestream
+	if ls == nil {
+		return
+	}
+
+	room.livestream = nil
+	if room.sfu != nil {
+		room.sfu.livestream.Store(nil)
+	}
+
+	log.Printf("📡 Livestream %s stopped in room %s", ls.id, room.ID)
+	go ls.stop()
+}
+
+func livestreamState(room *Room) livestreamView {
+	if room.livestream == nil {
+		return livestreamView{}
+	}
+	return livestreamView{
+		Active:    true,
+		URL:       "/live/" + room.livestream.id + "/" + livestreamPlaylist,
+		StartedAt: room.livestream.startedAt.UnixMilli(),
+	}
+}
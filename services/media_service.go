@@ -0,0 +1,182 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+var (
+	ErrMediaTooLarge = errors.New("file is too large")
+	ErrMediaType     = errors.New("only images and PDFs can be uploaded")
+)
+
+// Types are decided by sniffing the content, never by the client's claim.
+var allowedMediaTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+func mediaDir() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return "./media"
+}
+
+// MediaMaxBytes is the largest accepted upload.
+func MediaMaxBytes() int64 { return int64(envInt("MEDIA_MAX_BYTES", 10<<20)) }
+
+func mediaURLTTL() time.Duration { return envSeconds("MEDIA_URL_TTL", 15*time.Minute) }
+
+const mediaThumbSuffix = ".thumb"
+
+// MediaPath shards content-addressed files by hash prefix. Files carry no
+// extension and are only ever served with their sniffed type.
+func MediaPath(id string) string {
+	return filepath.Join(mediaDir(), id[:2], id[2:4], id)
+}
+
+func validMediaID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// StoreMedia validates an upload, strips metadata from images and stores
+// the result, with a thumbnail for images, at its content address.
+func StoreMedia(r io.Reader, filename, uploader string) (models.Media, error) {
+	var media models.Media
+
+	limit := MediaMaxBytes()
+	raw, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return media, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(raw)) > limit {
+		return media, ErrMediaTooLarge
+	}
+
+	contentType := http.DetectContentType(raw)
+	if !allowedMediaTypes[contentType] {
+		return media, ErrMediaType
+	}
+
+	data := raw
+	var thumb []byte
+	if strings.HasPrefix(contentType, "image/") {
+		img, err := processImage(raw, contentType)
+		if err != nil {
+			return media, err
+		}
+		data, thumb = img.Data, img.Thumbnail
+		contentType = img.ContentType
+		media.Width, media.Height = img.Width, img.Height
+		media.ThumbnailType = img.ThumbnailType
+	}
+
+	sum := sha256.Sum256(data)
+	media.ID = hex.EncodeToString(sum[:])
+	media.UploadedBy = uploader
+	media.ContentType = contentType
+	media.Size = int64(len(data))
+	media.Filename = truncateRunes(filepath.Base(filename), 255)
+
+	path := MediaPath(media.ID)
+	if err := writeMediaFile(path, data); err != nil {
+		return media, err
+	}
+	if thumb != nil {
+		if err := writeMediaFile(path+mediaThumbSuffix, thumb); err != nil {
+			return media, err
+		}
+	}
+
+	if err := config.DB.Where(models.Media{ID: media.ID}).FirstOrCreate(&media).Error; err != nil {
+		return media, fmt.Errorf("failed to record upload: %w", err)
+	}
+	return media, nil
+}
+
+// writeMediaFile writes a read-only file via rename so readers never see a
+// partial one. Content-addressed files that already exist are left alone.
+func writeMediaFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o440); err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store upload: %w", err)
+	}
+	return nil
+}
+
+func mediaSignature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	fmt.Fprintf(mac, "media:%s:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MediaURL is a short-lived signed download path for an uploaded file.
+func MediaURL(id string) string {
+	expires := time.Now().Add(mediaURLTTL()).Unix()
+	return fmt.Sprintf("/media/%s?expires=%d&sig=%s", id, expires, mediaSignature(id, expires))
+}
+
+// MediaThumbnailURL signs the thumbnail of an uploaded image.
+func MediaThumbnailURL(id string) string {
+	return MediaURL(id) + "&variant=thumb"
+}
+
+// MediaThumbnailPath is where an image's thumbnail is stored.
+func MediaThumbnailPath(id string) string {
+	return MediaPath(id) + mediaThumbSuffix
+}
+
+func VerifyMediaURL(id, expires, sig string) bool {
+	if os.Getenv("JWT_SECRET") == "" || !validMediaID(id) {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(mediaSignature(id, exp)), []byte(sig))
+}
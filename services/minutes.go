@@ -0,0 +1,383 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+const maxMinutesTextLength = 500
+
+// Minutes is the structured record of one room session, from the first join
+// until the room empties.
+type Minutes struct {
+	ID          string              `json:"id"`
+	RoomID      string              `json:"roomId"`
+	Title       string              `json:"title"`
+	StartedAt   time.Time           `json:"startedAt"`
+	EndedAt     time.Time           `json:"endedAt"`
+	Attendees   []MinutesAttendee   `json:"attendees"`
+	Agenda      []MinutesAgendaItem `json:"agenda"`
+	Votes       []MinutesVote       `json:"votes"`
+	SharedMedia []MinutesShare      `json:"sharedMedia"`
+	Highlights  []MinutesNote       `json:"highlights"`
+	Timeline    []MinutesEvent      `json:"timeline"`
+}
+
+type MinutesAttendee struct {
+	UserID    string    `json:"userId"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	PresentMs int64     `json:"presentMs"`
+	joinedAt  time.Time
+}
+
+type MinutesAgendaItem struct {
+	Title string    `json:"title"`
+	At    time.Time `json:"at"`
+}
+
+type MinutesVote struct {
+	Question   string    `json:"question"`
+	AgendaItem string    `json:"agendaItem,omitempty"`
+	Yes        int       `json:"yes"`
+	No         int       `json:"no"`
+	Total      int       `json:"total"`
+	Outcome    string    `json:"outcome"`
+	ClosedAt   time.Time `json:"closedAt"`
+}
+
+type MinutesShare struct {
+	UserID    string    `json:"userId"`
+	MediaType string    `json:"mediaType"`
+	Caption   string    `json:"caption,omitempty"`
+	ItemID    string    `json:"itemId"`
+	At        time.Time `json:"at"`
+}
+
+type MinutesNote struct {
+	UserID string    `json:"userId"`
+	Text   string    `json:"text"`
+	At     time.Time `json:"at"`
+}
+
+type MinutesEvent struct {
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`
+	UserID string    `json:"userId,omitempty"`
+	Text   string    `json:"text,omitempty"`
+}
+
+// minutesRecorder collects a session's minutes as signaling events happen.
+// Guarded by the room's lock like the rest of Room.
+type minutesRecorder struct {
+	m         *Minutes
+	attendees map[string]*MinutesAttendee
+}
+
+func newMinutesRecorder() *minutesRecorder {
+	return &minutesRecorder{}
+}
+
+func (r *minutesRecorder) begin(roomID string, now time.Time) {
+	if r.m != nil {
+		return
+	}
+	r.m = &Minutes{
+		ID:          uuid.New().String(),
+		RoomID:      roomID,
+		StartedAt:   now,
+		Attendees:   []MinutesAttendee{},
+		Agenda:      []MinutesAgendaItem{},
+		Votes:       []MinutesVote{},
+		SharedMedia: []MinutesShare{},
+		Highlights:  []MinutesNote{},
+		Timeline:    []MinutesEvent{},
+	}
+	r.attendees = make(map[string]*MinutesAttendee)
+}
+
+func (r *minutesRecorder) event(kind, userID, text string, now time.Time) {
+	r.m.Timeline = append(r.m.Timeline, MinutesEvent{At: now, Kind: kind, UserID: userID, Text: text})
+}
+
+func (r *minutesRecorder) joined(roomID, userID string, now time.Time) {
+	r.begin(roomID, now)
+	a, ok := r.attendees[userID]
+	if !ok {
+		a = &MinutesAttendee{UserID: userID, FirstSeen: now}
+		r.attendees[userID] = a
+	}
+	a.joinedAt = now
+	r.event("join", userID, "", now)
+}
+
+func (r *minutesRecorder) left(userID string, now time.Time) {
+	if r.m == nil {
+		return
+	}
+	if a, ok := r.attendees[userID]; ok && !a.joinedAt.IsZero() {
+		a.PresentMs += now.Sub(a.joinedAt).Milliseconds()
+		a.LastSeen = now
+		a.joinedAt = time.Time{}
+	}
+	r.event("leave", userID, "", now)
+}
+
+func (r *minutesRecorder) voteClosed(vote PastVote, now time.Time) {
+	if r.m == nil {
+		return
+	}
+	v := MinutesVote{
+		Question: vote.Question,
+		Yes:      vote.YesCount,
+		No:       vote.NoCount,
+		Total:    vote.TotalVotes,
+		Outcome:  voteOutcome(vote),
+		ClosedAt: now,
+	}
+	if n := len(r.m.Agenda); n > 0 {
+		v.AgendaItem = r.m.Agenda[n-1].Title
+	}
+	r.m.Votes = append(r.m.Votes, v)
+	r.event("vote", "", fmt.Sprintf("%s: %s (%d yes, %d no)", v.Question, v.Outcome, v.Yes, v.No), now)
+}
+
+func voteOutcome(vote PastVote) string {
+	switch {
+	case vote.TotalVotes == 0:
+		return "no votes"
+	case vote.YesCount > vote.NoCount:
+		return "passed"
+	case vote.NoCount > vote.YesCount:
+		return "rejected"
+	default:
+		return "tied"
+	}
+}
+
+func (r *minutesRecorder) shared(item models.GalleryItem) {
+	if r.m == nil {
+		return
+	}
+	r.m.SharedMedia = append(r.m.SharedMedia, MinutesShare{
+		UserID:    item.SharedBy,
+		MediaType: item.MediaType,
+		Caption:   item.Caption,
+		ItemID:    item.ID,
+		At:        item.SharedAt,
+	})
+	r.event("share", item.SharedBy, item.MediaType, item.SharedAt)
+}
+
+func (r *minutesRecorder) agendaItem(title string, now time.Time) {
+	if r.m == nil {
+		return
+	}
+	r.m.Agenda = append(r.m.Agenda, MinutesAgendaItem{Title: title, At: now})
+	r.event("agenda", "", title, now)
+}
+
+func (r *minutesRecorder) highlight(userID, text string, now time.Time) {
+	if r.m == nil {
+		return
+	}
+	r.m.Highlights = append(r.m.Highlights, MinutesNote{UserID: userID, Text: text, At: now})
+	r.event("highlight", userID, text, now)
+}
+
+// finish closes the session and persists its minutes in the background.
+func (r *minutesRecorder) finish(title string, now time.Time) {
+	if r.m == nil {
+		return
+	}
+	for id := range r.attendees {
+		if !r.attendees[id].joinedAt.IsZero() {
+			r.left(id, now)
+		}
+	}
+
+	m := r.m
+	m.Title = title
+	m.EndedAt = now
+	for _, a := range r.attendees {
+		m.Attendees = append(m.Attendees, *a)
+	}
+	sort.Slice(m.Attendees, func(i, j int) bool {
+		return m.Attendees[i].FirstSeen.Before(m.Attendees[j].FirstSeen)
+	})
+	*r = *newMinutesRecorder()
+
+	go saveMinutes(m)
+}
+
+func saveMinutes(m *Minutes) {
+	if config.DB == nil {
+		return
+	}
+	doc, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("❌ Failed to encode minutes for %s: %v", m.RoomID, err)
+		return
+	}
+	record := models.MeetingMinutes{
+		ID:        m.ID,
+		RoomID:    m.RoomID,
+		Title:     m.Title,
+		StartedAt: m.StartedAt,
+		EndedAt:   m.EndedAt,
+		Document:  string(doc),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		log.Printf("❌ Failed to persist minutes for %s: %v", m.RoomID, err)
+		return
+	}
+	log.Printf("📝 Saved minutes %s for room %s", m.ID, m.RoomID)
+}
+
+// addAgendaItem and addHighlight are the host's minute-taking messages.
+func addAgendaItem(host *Client, title string) {
+	title = truncateRunes(title, maxMinutesTextLength)
+	if title == "" {
+		return
+	}
+
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+	room.Minutes.agendaItem(title, time.Now())
+	broadcastMessage(room.ID, &agendaItemMessage{messageType: typed("agenda-item"), Title: title})
+}
+
+func addHighlight(host *Client, text string) {
+	text = truncateRunes(text, maxMinutesTextLength)
+	if text == "" {
+		return
+	}
+
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+	room.Minutes.highlight(host.ID, text, time.Now())
+}
+
+// RoomMinutes lists the minutes a room token grants, newest first.
+func RoomMinutes(grant RoomGrant) []models.MeetingMinutes {
+	var all []models.MeetingMinutes
+	if config.DB != nil {
+		config.DB.Where("room_id = ?", grant.RoomID).Order("ended_at desc").Find(&all)
+	}
+	list := []models.MeetingMinutes{}
+	for _, m := range all {
+		if grant.Covers(m.StartedAt) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// LoadMinutes returns one session's minutes if the room token grants them.
+func LoadMinutes(grant RoomGrant, id string) (*Minutes, bool) {
+	if config.DB == nil {
+		return nil, false
+	}
+	var record models.MeetingMinutes
+	if config.DB.Where("id = ? AND room_id = ?", id, grant.RoomID).Limit(1).Find(&record).RowsAffected == 0 ||
+		!grant.Covers(record.StartedAt) {
+		return nil, false
+	}
+	var m Minutes
+	if err := json.Unmarshal([]byte(record.Document), &m); err != nil {
+		log.Printf("❌ Minutes %s are unreadable: %v", id, err)
+		return nil, false
+	}
+	return &m, true
+}
+
+// MinutesMarkdown renders minutes for people rather than programs.
+func MinutesMarkdown(m *Minutes) string {
+	var b strings.Builder
+	title := m.Title
+	if title == "" {
+		title = "Room " + m.RoomID
+	}
+
+	fmt.Fprintf(&b, "# Minutes: %s\n\n", mdEscape(title))
+	fmt.Fprintf(&b, "- **Room:** %s\n", mdEscape(m.RoomID))
+	fmt.Fprintf(&b, "- **Started:** %s\n", m.StartedAt.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "- **Ended:** %s\n", m.EndedAt.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "- **Duration:** %s\n\n", m.EndedAt.Sub(m.StartedAt).Round(time.Second))
+
+	fmt.Fprintf(&b, "## Attendees (%d)\n\n", len(m.Attendees))
+	for _, a := range m.Attendees {
+		fmt.Fprintf(&b, "- %s (%s present)\n", mdEscape(a.UserID), (time.Duration(a.PresentMs) * time.Millisecond).Round(time.Second))
+	}
+
+	if len(m.Agenda) > 0 {
+		b.WriteString("\n## Agenda\n\n")
+		for i, item := range m.Agenda {
+			fmt.Fprintf(&b, "%d. %s (%s)\n", i+1, mdEscape(item.Title), item.At.UTC().Format("15:04"))
+		}
+	}
+
+	b.WriteString("\n## Votes\n\n")
+	if len(m.Votes) == 0 {
+		b.WriteString("No votes were held.\n")
+	} else {
+		b.WriteString("| Question | Agenda item | Yes | No | Total | Outcome |\n")
+		b.WriteString("|---|---|---|---|---|---|\n")
+		for _, v := range m.Votes {
+			fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %s |\n",
+				mdCell(v.Question), mdCell(v.AgendaItem), v.Yes, v.No, v.Total, v.Outcome)
+		}
+	}
+
+	if len(m.SharedMedia) > 0 {
+		b.WriteString("\n## Shared media\n\n")
+		for _, s := range m.SharedMedia {
+			line := fmt.Sprintf("- %s shared %s at %s", mdEscape(s.UserID), mdEscape(s.MediaType), s.At.UTC().Format("15:04"))
+			if s.Caption != "" {
+				line += ": " + mdEscape(s.Caption)
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	if len(m.Highlights) > 0 {
+		b.WriteString("\n## Highlights\n\n")
+		for _, h := range m.Highlights {
+			fmt.Fprintf(&b, "- %s (%s)\n", mdEscape(h.Text), h.At.UTC().Format("15:04"))
+		}
+	}
+
+	return b.String()
+}
+
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "\n", " ", "\r", " ",
+)
+
+func mdEscape(s string) string { return mdEscaper.Replace(s) }
+
+func mdCell(s string) string { return strings.ReplaceAll(mdEscape(s), "|", `\|`) }
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ws "github.com/gofiber/websocket/v2"
+)
+
+const outboxWriteWait = 10 * time.Second
+
+func outboxSize() int { return envInt("OUTBOUND_QUEUE_LEN", 256) }
+
+// outboxOverflow is what happens when a message that must not be lost
+// meets a full queue: "disconnect" (default) closes the socket so the
+// client resumes with a fresh room-state; "drop" discards the message.
+func outboxOverflow() string {
+	if os.Getenv("OUTBOUND_OVERFLOW") == "drop" {
+		return "drop"
+	}
+	return "disconnect"
+}
+
+// droppableMessages are frequent and superseded by the next one, so a full
+// queue sheds them first.
+var droppableMessages = map[string]bool{
+	"speaking":         true,
+	"reaction":         true,
+	"present-page":     true,
+	"speaking-warning": true,
+}
+
+// Outbound totals since start, reported on the dashboard.
+var (
+	outboundSent        atomic.Int64
+	outboundDropped     atomic.Int64
+	outboundCoalesced   atomic.Int64
+	outboundDisconnects atomic.Int64
+)
+
+// outbox is a connection's send queue. Senders never touch the socket: they
+// enqueue and return, and one writer goroutine per connection drains the
+// queue, so a slow client only delays itself. A pending room-state is
+// replaced by a newer one rather than queued twice. write and hangUp reach
+// the socket, or, for a client connected to another instance, relay there.
+type outbox struct {
+	conn   *ws.Conn
+	id     string
+	write  func(entry outboxEntry) error
+	hangUp func()
+
+	mu      sync.Mutex
+	queue   []outboxEntry
+	closing bool
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// newOutbox starts the writer for conn. The connection's handler owns the
+// outbox and must detach it before returning.
+func newOutbox(conn *ws.Conn) *outbox {
+	o := &outbox{conn: conn, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	o.write = func(entry outboxEntry) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(outboxWriteWait))
+		return conn.WriteMessage(ws.TextMessage, entry.data)
+	}
+	o.hangUp = func() { closeConn(conn) }
+	go o.run()
+	return o
+}
+
+// bind names the client the outbox writes to, for logging.
+func (o *outbox) bind(clientID string) {
+	o.mu.Lock()
+	o.id = clientID
+	o.mu.Unlock()
+}
+
+// outboxEntry is a message encoded at send time. Messages can share maps
+// and slices with room state, so they must not be read after the sender
+// releases the room's lock.
+type outboxEntry struct {
+	kind string
+	data []byte
+}
+
+func (o *outbox) push(msg serverMessage) {
+	kind := msg.kind()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("❌ Failed to encode %s message: %v", kind, err)
+		return
+	}
+	o.enqueue(outboxEntry{kind: kind, data: data})
+}
+
+// enqueue queues an already encoded message.
+func (o *outbox) enqueue(entry outboxEntry) {
+	kind := entry.kind
+	o.mu.Lock()
+	if o.closing || o.closed {
+		o.mu.Unlock()
+		return
+	}
+
+	if kind == "room-state" {
+		for i, pending := range o.queue {
+			if pending.kind == "room-state" {
+				o.queue = append(o.queue[:i], o.queue[i+1:]...)
+				outboundCoalesced.Add(1)
+				break
+			}
+		}
+	}
+
+	if len(o.queue) >= outboxSize() {
+		if droppableMessages[kind] || outboxOverflow() == "drop" {
+			o.mu.Unlock()
+			outboundDropped.Add(1)
+			return
+		}
+		o.queue = nil
+		o.closed = true
+		o.hangUp()
+		id := o.id
+		o.mu.Unlock()
+
+		outboundDisconnects.Add(1)
+		log.Printf("🐢 Disconnecting %s: outbound queue full", id)
+		o.signal()
+		return
+	}
+
+	o.queue = append(o.queue, entry)
+	o.mu.Unlock()
+	o.signal()
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// shutdown closes the socket once everything queued so far is written.
+func (o *outbox) shutdown() {
+	o.mu.Lock()
+	o.closing = true
+	o.mu.Unlock()
+	o.signal()
+}
+
+// detach stops the writer for good and waits for it to exit. fasthttp
+// reuses a connection once its handler returns, so after detach nothing
+// may touch conn.
+func (o *outbox) detach() {
+	o.mu.Lock()
+	if !o.closed {
+		o.queue = nil
+		o.closed = true
+		o.hangUp()
+	}
+	o.mu.Unlock()
+	o.signal()
+	<-o.done
+}
+
+// ended reports whether the server closed the connection.
+func (o *outbox) ended() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.closed
+}
+
+// closeConn ends a socket from outside its handler. A hijacked fasthttp
+// connection ignores Close until the handler returns, so expired deadlines
+// are what actually wake a blocked reader or writer.
+func closeConn(conn *ws.Conn) {
+	past := time.Unix(1, 0)
+	_ = conn.SetReadDeadline(past)
+	_ = conn.SetWriteDeadline(past)
+	_ = conn.Close()
+}
+
+func (o *outbox) depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue)
+}
+
+func (o *outbox) run() {
+	defer close(o.done)
+	for range o.wake {
+		for {
+			o.mu.Lock()
+			if o.closed {
+				o.mu.Unlock()
+				return
+			}
+			if len(o.queue) == 0 {
+				if o.closing {
+					o.closed = true
+					o.hangUp()
+					o.mu.Unlock()
+					return
+				}
+				o.mu.Unlock()
+				break
+			}
+			entry := o.queue[0]
+			o.queue[0] = outboxEntry{}
+			o.queue = o.queue[1:]
+			o.mu.Unlock()
+
+			if err := o.write(entry); err != nil {
+				o.mu.Lock()
+				if !o.closed {
+					o.queue = nil
+					o.closed = true
+					o.hangUp()
+				}
+				o.mu.Unlock()
+				return
+			}
+			outboundSent.Add(1)
+		}
+	}
+}
+
+// closedByServer tells a reader whether its connection was closed on
+// purpose rather than timing out.
+func closedByServer(client *Client, conn *ws.Conn) bool {
+	client.mu.Lock()
+	out := client.out
+	client.mu.Unlock()
+	return out == nil || out.conn != conn || out.ended()
+}
+
+// send queues a message for the client's current connection.
+func (c *Client) send(msg serverMessage) {
+	c.mu.Lock()
+	out := c.out
+	c.mu.Unlock()
+	if out != nil {
+		out.push(msg)
+	}
+}
+
+// outboundState summarizes queue depth across connections for the
+// dashboard.
+func outboundState() outboundView {
+	all := clients.all()
+	queued, deepest := 0, 0
+	for _, client := range all {
+		client.mu.Lock()
+		out := client.out
+		client.mu.Unlock()
+		if out == nil {
+			continue
+		}
+		d := out.depth()
+		queued += d
+		if d > deepest {
+			deepest = d
+		}
+	}
+	return outboundView{
+		Connections: len(all),
+		Queued:      queued,
+		MaxDepth:    deepest,
+		Sent:        outboundSent.Load(),
+		Dropped:     outboundDropped.Load(),
+		Coalesced:   outboundCoalesced.Load(),
+		Disconnects: outboundDisconnects.Load(),
+	}
+}
+
+// roomQueueDepth is the deepest outbound queue among a room's clients.
+// Caller must hold the room's lock.
+func roomQueueDepth(room *Room) int {
+	deepest := 0
+	for _, client := range room.Clients {
+		client.mu.Lock()
+		out := client.out
+		client.mu.Unlock()
+		if out != nil {
+			if d := out.depth(); d > deepest {
+				deepest = d
+			}
+		}
+	}
+	return deepest
+}
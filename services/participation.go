@@ -0,0 +1,184 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+// Voice activity flickers; speech resuming within turnGap continues the
+// same turn instead of starting a new one.
+const turnGap = 1500 * time.Millisecond
+
+type participantStats struct {
+	speaking      time.Duration
+	speakingSince time.Time
+	turnStart     time.Time
+	lastStop      time.Time
+	turns         int
+	votesCast     int
+}
+
+// participationTracker accumulates speaking time from `speaking` events for
+// the current meeting of a room. Guarded by the room's lock like the rest
+// of Room.
+type participationTracker struct {
+	startedAt time.Time
+	stats     map[string]*participantStats
+}
+
+func newParticipationTracker() *participationTracker {
+	return &participationTracker{stats: make(map[string]*participantStats)}
+}
+
+func (t *participationTracker) get(clientID string) *participantStats {
+	s, ok := t.stats[clientID]
+	if !ok {
+		s = &participantStats{}
+		t.stats[clientID] = s
+	}
+	return s
+}
+
+func (t *participationTracker) joined(clientID string, now time.Time) {
+	if t.startedAt.IsZero() {
+		t.startedAt = now
+	}
+	t.get(clientID)
+}
+
+// speaking records a start or stop and reports whether the state changed.
+func (t *participationTracker) speaking(clientID string, isSpeaking bool, now time.Time) bool {
+	s := t.get(clientID)
+	if isSpeaking {
+		if !s.speakingSince.IsZero() {
+			return false
+		}
+		s.speakingSince = now
+		if s.lastStop.IsZero() || now.Sub(s.lastStop) > turnGap {
+			s.turns++
+			s.turnStart = now
+		}
+		return true
+	}
+
+	if s.speakingSince.IsZero() {
+		return false
+	}
+	s.speaking += now.Sub(s.speakingSince)
+	s.speakingSince = time.Time{}
+	s.lastStop = now
+	return true
+}
+
+// current reports whether the client is speaking, for how long in this
+// turn, and for how long in the meeting overall.
+func (t *participationTracker) current(clientID string, now time.Time) (speaking bool, turn, total time.Duration) {
+	s, ok := t.stats[clientID]
+	if !ok || s.speakingSince.IsZero() {
+		return false, 0, 0
+	}
+	return true, now.Sub(s.turnStart), s.total(now)
+}
+
+func (t *participationTracker) voted(clientID string) {
+	t.get(clientID).votesCast++
+}
+
+// total includes segments still in progress.
+func (s *participantStats) total(now time.Time) time.Duration {
+	if s.speakingSince.IsZero() {
+		return s.speaking
+	}
+	return s.speaking + now.Sub(s.speakingSince)
+}
+
+func (t *participationTracker) entries(now time.Time) ([]models.ParticipationEntry, int64) {
+	var total time.Duration
+	for _, s := range t.stats {
+		total += s.total(now)
+	}
+
+	entries := make([]models.ParticipationEntry, 0, len(t.stats))
+	for id, s := range t.stats {
+		e := models.ParticipationEntry{
+			UserID:     id,
+			SpeakingMs: s.total(now).Milliseconds(),
+			Turns:      s.turns,
+			VotesCast:  s.votesCast,
+		}
+		if total > 0 {
+			e.Share = float64(s.total(now)) / float64(total)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].SpeakingMs != entries[j].SpeakingMs {
+			return entries[i].SpeakingMs > entries[j].SpeakingMs
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	return entries, total.Milliseconds()
+}
+
+// finish closes the meeting, persists its report and starts afresh for
+// whoever joins next.
+func (t *participationTracker) finish(roomID string, now time.Time) {
+	if t.startedAt.IsZero() {
+		return
+	}
+	for id := range t.stats {
+		t.speaking(id, false, now)
+	}
+
+	entries, total := t.entries(now)
+	report := models.ParticipationReport{
+		ID:              uuid.New().String(),
+		RoomID:          roomID,
+		StartedAt:       t.startedAt,
+		EndedAt:         now,
+		TotalSpeakingMs: total,
+		Participants:    entries,
+	}
+	*t = *newParticipationTracker()
+
+	go saveParticipationReport(report)
+}
+
+func saveParticipationReport(report models.ParticipationReport) {
+	if config.DB == nil {
+		return
+	}
+	if err := config.DB.Create(&report).Error; err != nil {
+		log.Printf("❌ Failed to persist participation report for %s: %v", report.RoomID, err)
+		return
+	}
+	log.Printf("📊 Saved participation report %s for room %s", report.ID, report.RoomID)
+}
+
+func participationState(room *Room) participationView {
+	entries, total := room.Activity.entries(time.Now())
+	return participationView{TotalSpeakingMs: total, Participants: entries}
+}
+
+// ParticipationReports lists the finished meetings a room token grants,
+// newest first.
+func ParticipationReports(grant RoomGrant) []models.ParticipationReport {
+	var all []models.ParticipationReport
+	if config.DB != nil {
+		config.DB.Preload("Participants").Where("room_id = ?", grant.RoomID).
+			Order("ended_at desc").Find(&all)
+	}
+	reports := []models.ParticipationReport{}
+	for _, r := range all {
+		if grant.Covers(r.StartedAt) {
+			reports = append(reports, r)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	maxPresentationZoom = 5.0
+	minPresentationZoom = 0.25
+)
+
+// presentation tracks where the presenter is in a shared PDF so late joiners
+// open on the same page. With Follow off, participants may browse freely and
+// only use the presenter's position as a hint.
+type presentation struct {
+	DocumentID  string    `json:"documentId"`
+	PresenterID string    `json:"presenterId"`
+	Page        int       `json:"page"`
+	Zoom        float64   `json:"zoom"`
+	Follow      bool      `json:"follow"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// canPresent allows the host, the current presenter, or whoever shared the
+// document. Caller must hold the room's lock.
+func canPresent(room *Room, client *Client, documentID string) bool {
+	if client.ID == room.HostID {
+		return true
+	}
+	if room.Presentation != nil && room.Presentation.DocumentID == documentID {
+		return room.Presentation.PresenterID == client.ID
+	}
+	for _, item := range room.Gallery {
+		if item.ID == documentID {
+			return item.SharedBy == client.ID
+		}
+	}
+	return false
+}
+
+func presentPage(client *Client, msg *presentPageMessage) {
+	documentID := msg.DocumentID
+
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if !canPresent(room, client, documentID) {
+		return
+	}
+	if !isPDFItem(room, documentID) {
+		sendError(client, "Only shared PDFs can be presented")
+		return
+	}
+
+	var p presentation
+	if room.Presentation != nil && room.Presentation.DocumentID == documentID {
+		p = *room.Presentation
+	} else {
+		// A new document starts a new presentation, following by default.
+		p = presentation{DocumentID: documentID, Zoom: 1, Follow: true}
+		log.Printf("📑 %s is presenting %s in room %s", client.ID, documentID, room.ID)
+	}
+	p.PresenterID = client.ID
+	p.Page = msg.Page
+	if msg.Zoom != nil {
+		p.Zoom = clampZoom(*msg.Zoom)
+	}
+	if msg.Follow != nil {
+		p.Follow = *msg.Follow
+	}
+	p.UpdatedAt = time.Now()
+	recordEvent(room, evPresenting, client.ID, p)
+
+	// Page turns are frequent; send the small event instead of full state.
+	broadcastMessage(room.ID, &outPresentPage{messageType: typed("present-page"), presentationView: *presentationState(room)})
+}
+
+func stopPresenting(client *Client) {
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.Presentation == nil || !canPresent(room, client, room.Presentation.DocumentID) {
+		return
+	}
+
+	recordEvent(room, evPresentStop, client.ID, nil)
+	broadcastRoomState(room.ID)
+}
+
+func isPDFItem(room *Room, itemID string) bool {
+	for _, item := range room.Gallery {
+		if item.ID == itemID {
+			return strings.HasPrefix(item.MediaType, "application/pdf")
+		}
+	}
+	return false
+}
+
+func clampZoom(zoom float64) float64 {
+	if zoom < minPresentationZoom {
+		return minPresentationZoom
+	}
+	if zoom > maxPresentationZoom {
+		return maxPresentationZoom
+	}
+	return zoom
+}
+
+func presentationState(room *Room) *presentationView {
+	p := room.Presentation
+	if p == nil {
+		return nil
+	}
+	return &presentationView{
+		DocumentID:  p.DocumentID,
+		PresenterID: p.PresenterID,
+		Page:        p.Page,
+		Zoom:        p.Zoom,
+		Follow:      p.Follow,
+		UpdatedAt:   p.UpdatedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,437 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Signaling protocol versions. Version 1 is what clients spoke before
+// versioning: unknown fields are ignored and malformed messages dropped.
+// From version 2, unknown fields are an error and a malformed message is
+// answered with an `invalid-message` error. A client asks for a version in
+// `init` and gets the highest one both sides speak.
+const (
+	minProtocolVersion = 1
+	ProtocolVersion    = 2
+)
+
+// Client messages are the structs below, one per type, registered in
+// clientMessages. Their tags are the protocol: a field is required unless
+// its json tag says omitempty, and a required string must not be empty.
+// enum lists a string's allowed values and min a number's lower bound.
+// The JSON Schema is generated from the same structs.
+
+type messageType struct {
+	Type string `json:"type"`
+}
+
+func (m messageType) kind() string { return m.Type }
+
+type clientMessage interface {
+	kind() string
+}
+
+// checker is a client message with rules its tags cannot express.
+type checker interface {
+	check() error
+}
+
+// initMessage claims the identity userId. connectionId names the
+// connection to resume when it differs, as it does under the multi policy.
+type initMessage struct {
+	messageType
+	UserID       string `json:"userId,omitempty"`
+	ConnectionID string `json:"connectionId,omitempty"`
+	ResumeToken  string `json:"resumeToken,omitempty"`
+	LastSeq      int64  `json:"lastSeq,omitempty" min:"0"`
+	Protocol     int    `json:"protocol,omitempty" min:"1"`
+	AuthToken    string `json:"authToken,omitempty"`
+}
+
+// bareMessage is a message with nothing but its type.
+type bareMessage struct {
+	messageType
+}
+
+type joinMessage struct {
+	messageType
+	RoomID    string `json:"roomId"`
+	IsCreator bool   `json:"isCreator,omitempty"`
+	Topology  string `json:"topology,omitempty" enum:"mesh,sfu,auto"`
+}
+
+type setTopologyMessage struct {
+	messageType
+	Topology string `json:"topology" enum:"mesh,sfu,auto"`
+}
+
+type topologyReadyMessage struct {
+	messageType
+	SwitchID string `json:"switchId"`
+}
+
+// sessionDescription and iceCandidate are the browser's
+// RTCSessionDescriptionInit and RTCIceCandidateInit. An empty candidate
+// marks the end of candidates, so it is kept when forwarded.
+type sessionDescription struct {
+	Type string `json:"type" enum:"offer,answer,pranswer,rollback"`
+	SDP  string `json:"sdp,omitempty"`
+}
+
+type iceCandidate struct {
+	Candidate        *string `json:"candidate,omitempty"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+type offerMessage struct {
+	messageType
+	UserID string             `json:"userId"`
+	Offer  sessionDescription `json:"offer"`
+}
+
+type answerMessage struct {
+	messageType
+	UserID string             `json:"userId"`
+	Answer sessionDescription `json:"answer"`
+}
+
+type iceCandidateMessage struct {
+	messageType
+	UserID    string       `json:"userId"`
+	Candidate iceCandidate `json:"candidate"`
+}
+
+type sfuAnswerMessage struct {
+	messageType
+	Answer sessionDescription `json:"answer"`
+}
+
+type sfuCandidateMessage struct {
+	messageType
+	Candidate iceCandidate `json:"candidate"`
+}
+
+// leaveMessage and speakingMessage may carry the sender's own ID, which
+// the server ignores.
+type leaveMessage struct {
+	messageType
+	UserID string `json:"userId,omitempty"`
+}
+
+type speakingMessage struct {
+	messageType
+	UserID     string `json:"userId,omitempty"`
+	IsSpeaking bool   `json:"isSpeaking"`
+}
+
+// userMessage targets one participant.
+type userMessage struct {
+	messageType
+	UserID string `json:"userId"`
+}
+
+type setStageModeMessage struct {
+	messageType
+	Enabled  bool     `json:"enabled"`
+	Speakers []string `json:"speakers,omitempty"`
+}
+
+type agendaItemMessage struct {
+	messageType
+	Title string `json:"title"`
+}
+
+type highlightMessage struct {
+	messageType
+	Text string `json:"text"`
+}
+
+type speakingLimitsMessage struct {
+	messageType
+	TurnSeconds  float64 `json:"turnSeconds,omitempty" min:"0"`
+	TotalSeconds float64 `json:"totalSeconds,omitempty" min:"0"`
+}
+
+type goAroundMessage struct {
+	messageType
+	Order       []string `json:"order,omitempty"`
+	SecondsEach float64  `json:"secondsEach" min:"1"`
+}
+
+type shareMediaMessage struct {
+	messageType
+	MediaID   string `json:"mediaId,omitempty"`
+	URL       string `json:"url,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+}
+
+func (m *shareMediaMessage) check() error {
+	if m.MediaID == "" && (m.URL == "" || m.MediaType == "") {
+		return errors.New("mediaId, or url with mediaType, is required")
+	}
+	if len(m.URL) > maxMediaURLLength {
+		return fmt.Errorf("url must be at most %d bytes", maxMediaURLLength)
+	}
+	return nil
+}
+
+// itemMessage targets one gallery item.
+type itemMessage struct {
+	messageType
+	ItemID string `json:"itemId"`
+}
+
+type presentPageMessage struct {
+	messageType
+	DocumentID string   `json:"documentId"`
+	Page       int      `json:"page" min:"1"`
+	Zoom       *float64 `json:"zoom,omitempty"`
+	Follow     *bool    `json:"follow,omitempty"`
+}
+
+type createVoteMessage struct {
+	messageType
+	Question string `json:"question"`
+}
+
+// voteMessage may carry the voter's ID too; votes are always counted
+// under the sender's connection.
+type voteMessage struct {
+	messageType
+	UserID string `json:"userId,omitempty"`
+	Value  string `json:"value" enum:"yes,no"`
+}
+
+type reactionMessage struct {
+	messageType
+	Kind  string `json:"kind" enum:"emoji,thumbs,fist-to-five"`
+	Value string `json:"value"`
+}
+
+func (m *reactionMessage) check() error {
+	if !validReaction(m.Kind, m.Value) {
+		return fmt.Errorf("invalid %s reaction %q", m.Kind, m.Value)
+	}
+	return nil
+}
+
+type createBreakoutsMessage struct {
+	messageType
+	Count           int            `json:"count"`
+	Mode            string         `json:"mode,omitempty" enum:"random,manual"`
+	DurationSeconds int            `json:"durationSeconds,omitempty" min:"0"`
+	Assignments     map[string]int `json:"assignments,omitempty"`
+}
+
+type assignBreakoutMessage struct {
+	messageType
+	UserID string `json:"userId"`
+	RoomID string `json:"roomId"`
+}
+
+type breakoutBroadcastMessage struct {
+	messageType
+	Message string `json:"message"`
+}
+
+// roomInfoMessage changes only the fields it carries.
+type roomInfoMessage struct {
+	messageType
+	Title  *string  `json:"title,omitempty"`
+	Topic  *string  `json:"topic,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Listed *bool    `json:"listed,omitempty"`
+}
+
+var clientMessages = map[string]func() clientMessage{
+	"init":                func() clientMessage { return &initMessage{} },
+	"join":                func() clientMessage { return &joinMessage{} },
+	"leave":               func() clientMessage { return &leaveMessage{} },
+	"set-topology":        func() clientMessage { return &setTopologyMessage{} },
+	"topology-ready":      func() clientMessage { return &topologyReadyMessage{} },
+	"offer":               func() clientMessage { return &offerMessage{} },
+	"answer":              func() clientMessage { return &answerMessage{} },
+	"ice-candidate":       func() clientMessage { return &iceCandidateMessage{} },
+	"sfu-join":            func() clientMessage { return &bareMessage{} },
+	"sfu-answer":          func() clientMessage { return &sfuAnswerMessage{} },
+	"sfu-ice-candidate":   func() clientMessage { return &sfuCandidateMessage{} },
+	"sfu-leave":           func() clientMessage { return &bareMessage{} },
+	"set-stage-mode":      func() clientMessage { return &setStageModeMessage{} },
+	"raise-hand":          func() clientMessage { return &bareMessage{} },
+	"lower-hand":          func() clientMessage { return &bareMessage{} },
+	"promote-speaker":     func() clientMessage { return &userMessage{} },
+	"demote-speaker":      func() clientMessage { return &userMessage{} },
+	"agenda-item":         func() clientMessage { return &agendaItemMessage{} },
+	"highlight":           func() clientMessage { return &highlightMessage{} },
+	"set-speaking-limits": func() clientMessage { return &speakingLimitsMessage{} },
+	"unmute-participant":  func() clientMessage { return &userMessage{} },
+	"start-go-around":     func() clientMessage { return &goAroundMessage{} },
+	"next-speaker":        func() clientMessage { return &bareMessage{} },
+	"yield-floor":         func() clientMessage { return &bareMessage{} },
+	"stop-go-around":      func() clientMessage { return &bareMessage{} },
+	"start-recording":     func() clientMessage { return &bareMessage{} },
+	"stop-recording":      func() clientMessage { return &bareMessage{} },
+	"start-livestream":    func() clientMessage { return &bareMessage{} },
+	"stop-livestream":     func() clientMessage { return &bareMessage{} },
+	"share-media":         func() clientMessage { return &shareMediaMessage{} },
+	"pin-media":           func() clientMessage { return &itemMessage{} },
+	"unpin-media":         func() clientMessage { return &itemMessage{} },
+	"remove-media":        func() clientMessage { return &itemMessage{} },
+	"clear-media":         func() clientMessage { return &bareMessage{} },
+	"present-page":        func() clientMessage { return &presentPageMessage{} },
+	"stop-presenting":     func() clientMessage { return &bareMessage{} },
+	"create-vote":         func() clientMessage { return &createVoteMessage{} },
+	"end-vote":            func() clientMessage { return &bareMessage{} },
+	"vote":                func() clientMessage { return &voteMessage{} },
+	"reaction":            func() clientMessage { return &reactionMessage{} },
+	"clear-reactions":     func() clientMessage { return &bareMessage{} },
+	"create-breakouts":    func() clientMessage { return &createBreakoutsMessage{} },
+	"assign-breakout":     func() clientMessage { return &assignBreakoutMessage{} },
+	"breakout-broadcast":  func() clientMessage { return &breakoutBroadcastMessage{} },
+	"close-breakouts":     func() clientMessage { return &bareMessage{} },
+	"update-room-info":    func() clientMessage { return &roomInfoMessage{} },
+	"speaking":            func() clientMessage { return &speakingMessage{} },
+}
+
+// negotiateProtocol picks the version for a client that asked for
+// requested, 0 meaning it did not say.
+func negotiateProtocol(requested int) int {
+	switch {
+	case requested == 0:
+		return minProtocolVersion
+	case requested > ProtocolVersion:
+		return ProtocolVersion
+	default:
+		return requested
+	}
+}
+
+// invalidMessage is the error a client gets for a message it should not
+// have sent.
+func invalidMessage(err error) *outError {
+	return &outError{messageType: typed("error"), Code: "invalid-message", Error: err.Error()}
+}
+
+// decodeMessage decodes and validates one client message. Under protocol
+// version 2 and later, unknown fields are rejected.
+func decodeMessage(raw []byte, version int) (clientMessage, error) {
+	var head messageType
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, errors.New("message is not a JSON object with a string type")
+	}
+	newMessage, ok := clientMessages[head.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", head.Type)
+	}
+
+	msg := newMessage()
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if version >= 2 {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(msg); err != nil {
+		return nil, fmt.Errorf("%s: %s", head.Type, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if err := checkFields(reflect.ValueOf(msg).Elem(), raw, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", head.Type, err)
+	}
+	if c, ok := msg.(checker); ok {
+		if err := c.check(); err != nil {
+			return nil, fmt.Errorf("%s: %w", head.Type, err)
+		}
+	}
+	return msg, nil
+}
+
+// messageField is one JSON field of a message struct and its rules.
+type messageField struct {
+	name     string
+	index    []int
+	typ      reflect.Type
+	required bool
+	enum     []string
+	min      *float64
+}
+
+func messageFields(t reflect.Type) []messageField {
+	fields := []messageField{}
+	for _, sf := range reflect.VisibleFields(t) {
+		if sf.Anonymous || !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		f := messageField{
+			name:     name,
+			index:    sf.Index,
+			typ:      sf.Type,
+			required: !strings.Contains(opts, "omitempty"),
+		}
+		if enum := sf.Tag.Get("enum"); enum != "" {
+			f.enum = strings.Split(enum, ",")
+		}
+		if min, err := strconv.ParseFloat(sf.Tag.Get("min"), 64); err == nil {
+			f.min = &min
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// checkFields applies the tag rules to a decoded struct, using raw to
+// tell a missing field from a zero one.
+func checkFields(v reflect.Value, raw []byte, path string) error {
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &present); err != nil {
+		return err
+	}
+
+	for _, f := range messageFields(v.Type()) {
+		name := path + f.name
+		value, ok := present[f.name]
+		if ok && string(value) == "null" {
+			ok = false
+		}
+		if !ok {
+			if f.required {
+				return fmt.Errorf("%s is required", name)
+			}
+			continue
+		}
+
+		fv := v.FieldByIndex(f.index)
+		for fv.Kind() == reflect.Pointer {
+			fv = fv.Elem()
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			s := fv.String()
+			if f.required && s == "" {
+				return fmt.Errorf("%s must not be empty", name)
+			}
+			if f.enum != nil && s != "" && !containsString(f.enum, s) {
+				return fmt.Errorf("%s must be one of %s", name, strings.Join(f.enum, ", "))
+			}
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			n := fv.Convert(reflect.TypeOf(float64(0))).Float()
+			if f.min != nil && n < *f.min {
+				return fmt.Errorf("%s must be at least %v", name, *f.min)
+			}
+		case reflect.Struct:
+			if err := checkFields(fv, value, name+"."); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+//go:generate go run ../cmd/protocol-schema ../client/src/lib/signaling.schema.json ../client/src/lib/signaling.ts
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// ProtocolSchema describes every message as JSON Schema, built from the
+// same structs and tags the server encodes and decodes with. Each message
+// is a definition named after its direction and type, e.g. client.join;
+// clientMessage and serverMessage accept any message in that direction,
+// and the root accepts a client message.
+func ProtocolSchema() map[string]interface{} {
+	defs := map[string]interface{}{}
+	for _, fromClient := range []bool{true, false} {
+		oneOf := []interface{}{}
+		for _, kind := range messageKinds(fromClient) {
+			name := protocolSide(fromClient) + "." + kind
+			schema := objectSchema(messageStruct(fromClient, kind), fromClient)
+			schema["properties"].(map[string]interface{})["type"] = map[string]interface{}{"const": kind}
+			defs[name] = schema
+			oneOf = append(oneOf, map[string]interface{}{"$ref": "#/$defs/" + name})
+		}
+		defs[protocolSide(fromClient)+"Message"] = map[string]interface{}{"oneOf": oneOf}
+	}
+
+	return map[string]interface{}{
+		"$schema":         "https://json-schema.org/draft/2020-12/schema",
+		"title":           "AgoraNet signaling protocol",
+		"protocolVersion": ProtocolVersion,
+		"$ref":            "#/$defs/clientMessage",
+		"$defs":           defs,
+	}
+}
+
+func protocolSide(fromClient bool) string {
+	if fromClient {
+		return "client"
+	}
+	return "server"
+}
+
+// messageKinds lists the message types in one direction, sorted.
+func messageKinds(fromClient bool) []string {
+	kinds := []string{}
+	if fromClient {
+		for kind := range clientMessages {
+			kinds = append(kinds, kind)
+		}
+	} else {
+		for kind := range serverMessages {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func messageStruct(fromClient bool, kind string) reflect.Type {
+	if fromClient {
+		return reflect.TypeOf(clientMessages[kind]()).Elem()
+	}
+	return reflect.TypeOf(serverMessages[kind]()).Elem()
+}
+
+// objectSchema describes a struct. Required strings from clients must not
+// be empty; the server's may be.
+func objectSchema(t reflect.Type, fromClient bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, f := range messageFields(t) {
+		schema := typeSchema(f.typ, fromClient)
+		if f.enum != nil {
+			schema["enum"] = f.enum
+		}
+		if f.min != nil {
+			schema["minimum"] = *f.min
+		}
+		if f.required {
+			required = append(required, f.name)
+			if fromClient && f.typ.Kind() == reflect.String {
+				schema["minLength"] = 1
+			}
+		}
+		properties[f.name] = schema
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func typeSchema(t reflect.Type, fromClient bool) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawJSONType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		// Browsers send null for some optional fields, e.g. sdpMid.
+		schema := typeSchema(t.Elem(), fromClient)
+		if kind, ok := schema["type"].(string); ok && kind != "object" {
+			schema["type"] = []string{kind, "null"}
+		}
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint16:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), fromClient)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), fromClient)}
+	case reflect.Struct:
+		return objectSchema(t, fromClient)
+	}
+	return map[string]interface{}{}
+}
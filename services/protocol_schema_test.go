@@ -0,0 +1,29 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// The client's copies of the schema and types must match the server's
+// messages; go generate ./services rewrites them.
+func TestGeneratedProtocolFiles(t *testing.T) {
+	schema, err := json.MarshalIndent(ProtocolSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"../client/src/lib/signaling.schema.json": string(schema) + "\n",
+		"../client/src/lib/signaling.ts":          ProtocolTypeScript(),
+	}
+	for path, generated := range want {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != generated {
+			t.Errorf("%s is out of date; run go generate ./services", path)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"github.com/pion/webrtc/v4"
+
+	"github.com/nbursa/agoranet/models"
+)
+
+// Server messages are the structs below, registered in serverMessages;
+// the server sends nothing else. Their tags mean what they mean for client
+// messages, except that a field without omitempty is always sent, though a
+// string may be empty. The JSON Schema and the client's TypeScript types
+// cover both directions. Where a client message has the same shape, the
+// server reuses it.
+
+type serverMessage interface {
+	kind() string
+}
+
+// outInit answers init, and a resume on a new connection.
+type outInit struct {
+	messageType
+	UserID          string `json:"userId"`
+	Identity        string `json:"identity,omitempty"`
+	ResumeToken     string `json:"resumeToken"`
+	DuplicatePolicy string `json:"duplicatePolicy,omitempty" enum:"reject,takeover,multi"`
+	Resumed         bool   `json:"resumed,omitempty"`
+	Protocol        int    `json:"protocol"`
+}
+
+type outError struct {
+	messageType
+	Code  string `json:"code,omitempty" enum:"invalid-message,duplicate-id"`
+	Error string `json:"error"`
+}
+
+// outOffer, outAnswer and outICECandidate are mesh messages forwarded to
+// userId, the recipient, from another member of the room.
+type outOffer struct {
+	messageType
+	UserID string             `json:"userId"`
+	From   string             `json:"from"`
+	Offer  sessionDescription `json:"offer"`
+}
+
+type outAnswer struct {
+	messageType
+	UserID string             `json:"userId"`
+	From   string             `json:"from"`
+	Answer sessionDescription `json:"answer"`
+}
+
+type outICECandidate struct {
+	messageType
+	UserID    string       `json:"userId"`
+	From      string       `json:"from"`
+	Candidate iceCandidate `json:"candidate"`
+}
+
+type outSFUOffer struct {
+	messageType
+	Offer sessionDescription `json:"offer"`
+}
+
+type outRoomState struct {
+	messageType
+	Seq          int64             `json:"seq"`
+	Users        []string          `json:"users"`
+	Identities   map[string]string `json:"identities"`
+	Latency      map[string]int64  `json:"latency"`
+	HostID       string            `json:"hostId"`
+	ActiveVote   string            `json:"activeVote"`
+	CurrentVotes map[string]string `json:"currentVotes"`
+	RoomInfo     roomInfoView      `json:"roomInfo"`
+	Topology     string            `json:"topology"`
+	TopologyInfo topologyView      `json:"topologyInfo"`
+	Recording    recordingView     `json:"recording"`
+	Livestream   livestreamView    `json:"livestream"`
+	Stage        stageView         `json:"stage"`
+	Floor        floorView         `json:"floor"`
+	// SharedMedia is the latest gallery item.
+	SharedMedia    *SharedMedia      `json:"sharedMedia,omitempty"`
+	Presentation   *presentationView `json:"presentation,omitempty"`
+	ParentRoomID   string            `json:"parentRoomId,omitempty"`
+	BreakoutEndsAt int64             `json:"breakoutEndsAt,omitempty"`
+
+	// Only the host gets these.
+	HostToken     string             `json:"hostToken,omitempty"`
+	VoteHistory   []PastVote         `json:"voteHistory,omitempty"`
+	Reactions     *reactionsView     `json:"reactions,omitempty"`
+	Participation *participationView `json:"participation,omitempty"`
+	Breakouts     []breakoutView     `json:"breakouts,omitempty"`
+}
+
+type roomInfoView struct {
+	Title  string   `json:"title"`
+	Topic  string   `json:"topic"`
+	Tags   []string `json:"tags"`
+	Listed bool     `json:"listed"`
+}
+
+type topologyView struct {
+	Mode       string         `json:"mode" enum:"manual,auto"`
+	SwitchUp   int            `json:"switchUp"`
+	SwitchDown int            `json:"switchDown"`
+	Migration  *migrationView `json:"migration,omitempty"`
+}
+
+type migrationView struct {
+	SwitchID string `json:"switchId"`
+	To       string `json:"to"`
+}
+
+type recordingView struct {
+	Active    bool   `json:"active"`
+	ID        string `json:"id,omitempty"`
+	StartedAt int64  `json:"startedAt,omitempty"`
+}
+
+type livestreamView struct {
+	Active    bool   `json:"active"`
+	URL       string `json:"url,omitempty"`
+	StartedAt int64  `json:"startedAt,omitempty"`
+}
+
+// stageView counts speakers and listeners only in stage mode.
+type stageView struct {
+	Enabled       bool     `json:"enabled"`
+	RaisedHands   []string `json:"raisedHands"`
+	Speakers      []string `json:"speakers,omitempty"`
+	SpeakerCount  *int     `json:"speakerCount,omitempty"`
+	ListenerCount *int     `json:"listenerCount,omitempty"`
+}
+
+type floorView struct {
+	TurnLimitSeconds  float64           `json:"turnLimitSeconds"`
+	TotalLimitSeconds float64           `json:"totalLimitSeconds"`
+	Muted             map[string]string `json:"muted"`
+	GoAround          *goAroundView     `json:"goAround,omitempty"`
+}
+
+type goAroundView struct {
+	Order   []string `json:"order"`
+	Holder  string   `json:"holder"`
+	EndsAt  int64    `json:"endsAt"`
+	Seconds float64  `json:"seconds"`
+}
+
+type presentationView struct {
+	DocumentID  string  `json:"documentId"`
+	PresenterID string  `json:"presenterId"`
+	Page        int     `json:"page"`
+	Zoom        float64 `json:"zoom"`
+	Follow      bool    `json:"follow"`
+	UpdatedAt   int64   `json:"updatedAt"`
+}
+
+// SharedMedia is a gallery item as clients see it. Uploaded media carry
+// mediaId, and url is a freshly signed link to them.
+type SharedMedia struct {
+	Type         string `json:"type" enum:"shared-media"`
+	ItemID       string `json:"itemId"`
+	UserID       string `json:"userId"`
+	URL          string `json:"url"`
+	MediaType    string `json:"mediaType"`
+	Caption      string `json:"caption"`
+	Pinned       bool   `json:"pinned"`
+	SharedAt     int64  `json:"sharedAt"`
+	MediaID      string `json:"mediaId,omitempty"`
+	Filename     string `json:"filename,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+}
+
+type reactionsView struct {
+	Emoji      map[string]int `json:"emoji"`
+	Thumbs     map[string]int `json:"thumbs"`
+	FistToFive fistToFiveView `json:"fistToFive"`
+}
+
+// fistToFiveView has an average once anyone has answered.
+type fistToFiveView struct {
+	Responses    int      `json:"responses"`
+	Distribution []int    `json:"distribution"`
+	Average      *float64 `json:"average,omitempty"`
+}
+
+type participationView struct {
+	TotalSpeakingMs int64                       `json:"totalSpeakingMs"`
+	Participants    []models.ParticipationEntry `json:"participants"`
+}
+
+type breakoutView struct {
+	RoomID string   `json:"roomId"`
+	Users  []string `json:"users"`
+}
+
+type outGallery struct {
+	messageType
+	RoomID string        `json:"roomId"`
+	Items  []SharedMedia `json:"items"`
+}
+
+type outGalleryItem struct {
+	messageType
+	Item SharedMedia `json:"item"`
+}
+
+type outPresentPage struct {
+	messageType
+	presentationView
+}
+
+type outResumed struct {
+	messageType
+	RoomID   string             `json:"roomId"`
+	Events   []models.RoomEvent `json:"events"`
+	Complete bool               `json:"complete"`
+}
+
+type outSpeaking struct {
+	messageType
+	UserID     string `json:"userId"`
+	IsSpeaking bool   `json:"isSpeaking"`
+}
+
+type outReaction struct {
+	messageType
+	UserID string `json:"userId"`
+	Kind   string `json:"kind" enum:"emoji,thumbs,fist-to-five"`
+	Value  string `json:"value"`
+}
+
+type outStageRole struct {
+	messageType
+	Role string `json:"role" enum:"speaker,listener"`
+}
+
+type outSpeakingWarning struct {
+	messageType
+	Limit       string `json:"limit" enum:"turn,total"`
+	RemainingMs int64  `json:"remainingMs"`
+}
+
+// outForceMute has until unless the mute lasts until the host lifts it.
+type outForceMute struct {
+	messageType
+	Reason string `json:"reason"`
+	Until  int64  `json:"until,omitempty"`
+}
+
+type outFloor struct {
+	messageType
+	UserID   string `json:"userId"`
+	EndsAt   int64  `json:"endsAt"`
+	Position int    `json:"position"`
+	Total    int    `json:"total"`
+}
+
+type outMoveToRoom struct {
+	messageType
+	RoomID       string `json:"roomId"`
+	ParentRoomID string `json:"parentRoomId"`
+}
+
+type outBreakoutBroadcast struct {
+	messageType
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+type outTopologySwitch struct {
+	messageType
+	SwitchID string `json:"switchId"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+type outTopologyCommit struct {
+	messageType
+	SwitchID string `json:"switchId"`
+	Topology string `json:"topology"`
+}
+
+type outTopologyAbort struct {
+	messageType
+	SwitchID string `json:"switchId"`
+}
+
+// outDashboardSummary is what the /dashboard socket sends.
+type outDashboardSummary struct {
+	messageType
+	Rooms    []dashboardRoomView `json:"rooms"`
+	Outbound outboundView        `json:"outbound"`
+}
+
+type dashboardRoomView struct {
+	RoomID           string             `json:"roomId"`
+	HostID           string             `json:"hostId"`
+	ParticipantCount int                `json:"participantCount"`
+	Topology         string             `json:"topology"`
+	MaxQueueDepth    int                `json:"maxQueueDepth"`
+	Instance         string             `json:"instance"`
+	ParentRoomID     string             `json:"parentRoomId,omitempty"`
+	SpeakerCount     *int               `json:"speakerCount,omitempty"`
+	ListenerCount    *int               `json:"listenerCount,omitempty"`
+	ActiveVote       *dashboardVoteView `json:"activeVote,omitempty"`
+}
+
+type dashboardVoteView struct {
+	Question string `json:"question"`
+	Yes      int    `json:"yes"`
+	No       int    `json:"no"`
+}
+
+type outboundView struct {
+	Connections int   `json:"connections"`
+	Queued      int   `json:"queued"`
+	MaxDepth    int   `json:"maxDepth"`
+	Sent        int64 `json:"sent"`
+	Dropped     int64 `json:"dropped"`
+	Coalesced   int64 `json:"coalesced"`
+	Disconnects int64 `json:"disconnects"`
+}
+
+var serverMessages = map[string]func() serverMessage{
+	"init":                 func() serverMessage { return &outInit{} },
+	"error":                func() serverMessage { return &outError{} },
+	"session-replaced":     func() serverMessage { return &bareMessage{} },
+	"room-state":           func() serverMessage { return &outRoomState{} },
+	"gallery":              func() serverMessage { return &outGallery{} },
+	"gallery-item":         func() serverMessage { return &outGalleryItem{} },
+	"gallery-item-removed": func() serverMessage { return &itemMessage{} },
+	"resumed":              func() serverMessage { return &outResumed{} },
+	"peer-reconnecting":    func() serverMessage { return &userMessage{} },
+	"peer-resumed":         func() serverMessage { return &userMessage{} },
+	"leave":                func() serverMessage { return &userMessage{} },
+	"offer":                func() serverMessage { return &outOffer{} },
+	"answer":               func() serverMessage { return &outAnswer{} },
+	"ice-candidate":        func() serverMessage { return &outICECandidate{} },
+	"sfu-offer":            func() serverMessage { return &outSFUOffer{} },
+	"sfu-ice-candidate":    func() serverMessage { return &sfuCandidateMessage{} },
+	"speaking":             func() serverMessage { return &outSpeaking{} },
+	"reaction":             func() serverMessage { return &outReaction{} },
+	"present-page":         func() serverMessage { return &outPresentPage{} },
+	"stage-role":           func() serverMessage { return &outStageRole{} },
+	"speaking-warning":     func() serverMessage { return &outSpeakingWarning{} },
+	"force-mute":           func() serverMessage { return &outForceMute{} },
+	"unmute":               func() serverMessage { return &bareMessage{} },
+	"floor":                func() serverMessage { return &outFloor{} },
+	"go-around-ended":      func() serverMessage { return &bareMessage{} },
+	"agenda-item":          func() serverMessage { return &agendaItemMessage{} },
+	"move-to-room":         func() serverMessage { return &outMoveToRoom{} },
+	"breakout-broadcast":   func() serverMessage { return &outBreakoutBroadcast{} },
+	"topology-switch":      func() serverMessage { return &outTopologySwitch{} },
+	"topology-commit":      func() serverMessage { return &outTopologyCommit{} },
+	"topology-abort":       func() serverMessage { return &outTopologyAbort{} },
+	"dashboard-summary":    func() serverMessage { return &outDashboardSummary{} },
+}
+
+// duplicateIDError refuses a connection whose user ID is taken.
+func duplicateIDError() *outError {
+	return &outError{messageType: typed("error"), Code: "duplicate-id", Error: "This user ID is already connected"}
+}
+
+// typed names a message's type for a struct literal.
+func typed(kind string) messageType {
+	return messageType{Type: kind}
+}
+
+// Conversions from pion's types, which carry the same JSON.
+
+func descriptionOf(desc webrtc.SessionDescription) sessionDescription {
+	return sessionDescription{Type: desc.Type.String(), SDP: desc.SDP}
+}
+
+func candidateOf(c webrtc.ICECandidateInit) iceCandidate {
+	return iceCandidate{
+		Candidate:        &c.Candidate,
+		SDPMid:           c.SDPMid,
+		SDPMLineIndex:    c.SDPMLineIndex,
+		UsernameFragment: c.UsernameFragment,
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import "testing"
+
+func TestShareMediaNeedsMediaIDOrURL(t *testing.T) {
+	cases := map[string]bool{
+		`{"type":"share-media","mediaId":"m1"}`:                              true,
+		`{"type":"share-media","url":"https://x/a.png","mediaType":"image"}`: true,
+		`{"type":"share-media","mediaType":"image"}`:                         false,
+		`{"type":"share-media","url":"","mediaType":"image"}`:                false,
+		`{"type":"share-media","url":"https://x/a.png"}`:                     false,
+	}
+	for raw, valid := range cases {
+		_, err := decodeMessage([]byte(raw), ProtocolVersion)
+		if valid && err != nil {
+			t.Errorf("%s was rejected: %v", raw, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s was accepted", raw)
+		}
+	}
+}
+
+func TestDecodeMessageChecksTags(t *testing.T) {
+	cases := []struct {
+		raw     string
+		version int
+		valid   bool
+	}{
+		// Unknown fields are ignored before version 2 and rejected from it.
+		{`{"type":"raise-hand","extra":1}`, 1, true},
+		{`{"type":"raise-hand","extra":1}`, 2, false},
+		{`{"type":"join","roomId":"r","extra":1}`, 2, false},
+		// Required fields must be present and, if strings, not empty.
+		{`{"type":"join"}`, 2, false},
+		{`{"type":"join","roomId":""}`, 2, false},
+		{`{"type":"join","roomId":null}`, 2, false},
+		{`{"type":"join","roomId":"r"}`, 2, true},
+		// Nested structs are checked too.
+		{`{"type":"offer","userId":"u","offer":{}}`, 2, false},
+		{`{"type":"offer","userId":"u","offer":{"type":"offer","sdp":"v=0"}}`, 2, true},
+		// enum
+		{`{"type":"vote","userId":"u","value":"maybe"}`, 2, false},
+		{`{"type":"vote","userId":"u","value":"yes"}`, 2, true},
+		{`{"type":"join","roomId":"r","topology":"star"}`, 2, false},
+		{`{"type":"join","roomId":"r","topology":"sfu"}`, 2, true},
+		{`{"type":"offer","userId":"u","offer":{"type":"bogus"}}`, 2, false},
+		// min
+		{`{"type":"present-page","documentId":"d","page":0}`, 2, false},
+		{`{"type":"present-page","documentId":"d","page":1}`, 2, true},
+		{`{"type":"start-go-around","secondsEach":0.5}`, 2, false},
+		{`{"type":"set-speaking-limits","turnSeconds":-1}`, 2, false},
+		// The envelope itself.
+		{`[]`, 2, false},
+		{`{"type":"no-such-message"}`, 2, false},
+	}
+	for _, c := range cases {
+		_, err := decodeMessage([]byte(c.raw), c.version)
+		if c.valid && err != nil {
+			t.Errorf("v%d rejected %s: %v", c.version, c.raw, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("v%d accepted %s", c.version, c.raw)
+		}
+	}
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+	cases := map[int]int{
+		0:                   minProtocolVersion,
+		1:                   1,
+		ProtocolVersion:     ProtocolVersion,
+		ProtocolVersion + 5: ProtocolVersion,
+	}
+	for requested, want := range cases {
+		if got := negotiateProtocol(requested); got != want {
+			t.Errorf("negotiateProtocol(%d) = %d, want %d", requested, got, want)
+		}
+	}
+}
+
+func TestInvalidMessageReply(t *testing.T) {
+	p := connectPeer(t, "invalid-reply")
+
+	receiveMessage(p.client, []byte(`{"type":"join","roomId":"invalid-reply-room","extra":true}`), ProtocolVersion)
+	waitUntil(t, "the peer is told its message was invalid", func() bool {
+		return p.saw("error", func(msg map[string]interface{}) bool { return msg["code"] == "invalid-message" })
+	})
+	if p.client.roomID() != "" {
+		t.Fatal("an invalid join was handled")
+	}
+
+	// Version 1 clients never expected a reply.
+	v1 := connectPeer(t, "invalid-v1")
+	receiveMessage(v1.client, []byte(`{"type":"join"}`), 1)
+	receiveMessage(v1.client, []byte(`{"type":"join","roomId":"invalid-v1-room","isCreator":true,"extra":true}`), 1)
+	if v1.client.roomID() != "invalid-v1-room" {
+		t.Fatal("a version 1 join with an unknown field was not handled")
+	}
+	if v1.saw("error", nil) {
+		t.Fatal("a version 1 client was sent an invalid-message error")
+	}
+}
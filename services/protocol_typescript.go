@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ProtocolTypeScript renders the messages as TypeScript types for the
+// client, from the same structs as ProtocolSchema: ClientMessage and
+// ServerMessage are unions of one interface per message, e.g. ClientJoin
+// and ServerRoomState. Structs inside messages become interfaces named
+// after the Go type, without a View suffix.
+func ProtocolTypeScript() string {
+	g := &tsGenerator{named: map[string]reflect.Type{}}
+	var b strings.Builder
+	b.WriteString("// Code generated by cmd/protocol-schema; DO NOT EDIT.\n// Run go generate ./services after changing a message.\n\n")
+	fmt.Fprintf(&b, "export const PROTOCOL_VERSION = %d;\n", ProtocolVersion)
+
+	for _, fromClient := range []bool{true, false} {
+		side := tsName(protocolSide(fromClient))
+		names := []string{}
+		for _, kind := range messageKinds(fromClient) {
+			name := side + tsName(kind)
+			names = append(names, name)
+			fmt.Fprintf(&b, "\nexport interface %s %s\n", name, g.object(messageStruct(fromClient, kind), kind))
+		}
+		fmt.Fprintf(&b, "\nexport type %sMessage =\n  | %s;\n", side, strings.Join(names, "\n  | "))
+	}
+
+	// Nested structs come last, once each, in name order. Rendering one
+	// can name more.
+	rendered := map[string]string{}
+	for len(rendered) < len(g.named) {
+		for name, t := range g.named {
+			if _, ok := rendered[name]; !ok {
+				rendered[name] = g.object(t, "")
+			}
+		}
+	}
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\nexport interface %s %s\n", name, rendered[name])
+	}
+	return b.String()
+}
+
+type tsGenerator struct {
+	named map[string]reflect.Type
+}
+
+// object renders a struct's fields; kind, if set, fixes its type field.
+func (g *tsGenerator) object(t reflect.Type, kind string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, f := range messageFields(t) {
+		typ := g.typeOf(f.typ)
+		switch {
+		case kind != "" && f.name == "type":
+			typ = fmt.Sprintf("%q", kind)
+		case f.enum != nil:
+			quoted := make([]string, len(f.enum))
+			for i, v := range f.enum {
+				quoted[i] = fmt.Sprintf("%q", v)
+			}
+			typ = strings.Join(quoted, " | ")
+		}
+		optional := ""
+		if !f.required {
+			optional = "?"
+		}
+		fmt.Fprintf(&b, "  %s%s: %s;\n", f.name, optional, typ)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func (g *tsGenerator) typeOf(t reflect.Type) string {
+	switch t {
+	case timeType:
+		return "string"
+	case rawJSONType:
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		// Matches the schema: optional scalars may be null.
+		if t.Elem().Kind() == reflect.Struct {
+			return g.typeOf(t.Elem())
+		}
+		return g.typeOf(t.Elem()) + " | null"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Uint16, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return g.typeOf(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Record<string, " + g.typeOf(t.Elem()) + ">"
+	case reflect.Struct:
+		name := tsName(strings.TrimSuffix(t.Name(), "View"))
+		if seen, ok := g.named[name]; ok && seen != t {
+			panic(fmt.Sprintf("protocol: %s and %s are both %s in TypeScript", seen, t, name))
+		}
+		g.named[name] = t
+		return name
+	}
+	return "unknown"
+}
+
+// tsName turns a message type or Go type name into an exported TypeScript
+// name: room-state becomes RoomState, iceCandidate IceCandidate.
+func tsName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "-") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
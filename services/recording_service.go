@@ -0,0 +1,293 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+const (
+	recordingManifest = "manifest.json"
+	recordingMix      = "mixed.ogg"
+)
+
+func recordingsDir() string {
+	if dir := os.Getenv("RECORDINGS_DIR"); dir != "" {
+		return dir
+	}
+	return "./recordings"
+}
+
+// RecordingDir is where a recording's Ogg files and manifest live.
+func RecordingDir(recordingID string) string {
+	return filepath.Join(recordingsDir(), recordingID)
+}
+
+// RecordedTrack describes one participant track in a recording. OffsetMs
+// is how far into the recording the track's first packet arrived, so the
+// files can be lined up again when mixing.
+type RecordedTrack struct {
+	UserID   string `json:"userId"`
+	File     string `json:"file"`
+	OffsetMs int64  `json:"offsetMs"`
+}
+
+// roomRecorder writes every forwarded Opus track of an SFU room to its own
+// Ogg file while a recording is running.
+type roomRecorder struct {
+	id        string
+	roomID    string
+	dir       string
+	startedAt time.Time
+
+	mu      sync.Mutex
+	writers map[string]*oggwriter.OggWriter
+	tracks  []RecordedTrack
+	perUser map[string]int
+	stopped bool
+}
+
+func newRoomRecorder(roomID string) (*roomRecorder, error) {
+	id := uuid.New().String()
+	dir := RecordingDir(id)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	return &roomRecorder{
+		id:        id,
+		roomID:    roomID,
+		dir:       dir,
+		startedAt: time.Now(),
+		writers:   make(map[string]*oggwriter.OggWriter),
+		perUser:   make(map[string]int),
+	}, nil
+}
+
+// write appends a packet of trackID, opening the track's file on first use.
+func (r *roomRecorder) write(trackID, ownerID string, pkt *rtp.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	w, ok := r.writers[trackID]
+	if !ok {
+		r.perUser[ownerID]++
+		name := fmt.Sprintf("%s-%d.ogg", safeFileComponent(ownerID), r.perUser[ownerID])
+		var err error
+		w, err = oggwriter.New(filepath.Join(r.dir, name), 48000, 2)
+		if err != nil {
+			log.Printf("❌ Recording %s could not open %s: %v", r.id, name, err)
+			return
+		}
+		r.writers[trackID] = w
+		r.tracks = append(r.tracks, RecordedTrack{
+			UserID:   ownerID,
+			File:     name,
+			OffsetMs: time.Since(r.startedAt).Milliseconds(),
+		})
+	}
+
+	if err := w.WriteRTP(pkt); err != nil {
+		log.Printf("⚠️ Recording %s dropped packet for %s: %v", r.id, ownerID, err)
+	}
+}
+
+// stop closes every file and writes the manifest.
+func (r *roomRecorder) stop() []RecordedTrack {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return r.tracks
+	}
+	r.stopped = true
+
+	for _, w := range r.writers {
+		_ = w.Close()
+	}
+
+	manifest, _ := json.MarshalIndent(map[string]interface{}{
+		"recordingId": r.id,
+		"roomId":      r.roomID,
+		"startedAt":   r.startedAt,
+		"tracks":      r.tracks,
+	}, "", "  ")
+	if err := os.WriteFile(filepath.Join(r.dir, recordingManifest), manifest, 0o640); err != nil {
+		log.Printf("❌ Recording %s could not write manifest: %v", r.id, err)
+	}
+	return r.tracks
+}
+
+func safeFileComponent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// startRecording begins recording a room on the host's request. Recording
+// needs the server-side media path, so the room must be on the SFU.
+func startRecording(host *Client) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || room.recording != nil {
+		return
+	}
+
+	session := roomSFU(room, room.ID)
+	if session == nil || room.Topology != topologySFU {
+		sendError(host, "Recording requires the room to use SFU topology")
+		return
+	}
+
+	rec, err := newRoomRecorder(room.ID)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		sendError(host, "Could not start recording")
+		return
+	}
+
+	room.recording = rec
+	session.recorder.Store(rec)
+
+	if config.DB != nil {
+		config.DB.Create(&models.Recording{
+			ID:        rec.id,
+			RoomID:    room.ID,
+			StartedBy: host.ID,
+			Status:    models.RecordingActive,
+			StartedAt: rec.startedAt,
+		})
+	}
+
+	log.Printf("⏺️ Recording %s started in room %s", rec.id, room.ID)
+	broadcastRoomState(room.ID)
+}
+
+// stopRecording ends the room's recording, if any, and finalizes it in the
+// background. Caller must hold the room's lock.
+func stopRecording(room *Room) {
+	rec := room.recording
+	if rec == nil {
+		return
+	}
+
+	room.recording = nil
+	if room.sfu != nil {
+		room.sfu.recorder.Store(nil)
+	}
+
+	log.Printf("⏹️ Recording %s stopped in room %s", rec.id, room.ID)
+	go finalizeRecording(rec)
+}
+
+func finalizeRecording(rec *roomRecorder) {
+	tracks := rec.stop()
+	ended := time.Now()
+	updateRecording(rec.id, models.RecordingProcessing, &ended)
+
+	status := models.RecordingReady
+	if err := mixRecording(rec.dir, tracks); err != nil {
+		log.Printf("⚠️ Recording %s has no mixed track: %v", rec.id, err)
+		if len(tracks) == 0 {
+			status = models.RecordingFailed
+		}
+	}
+	updateRecording(rec.id, status, &ended)
+}
+
+func updateRecording(id, status string, endedAt *time.Time) {
+	if config.DB == nil {
+		return
+	}
+	config.DB.Model(&models.Recording{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "ended_at": endedAt})
+}
+
+// mixRecording renders all tracks, aligned by their offsets, into a single
+// Opus file with ffmpeg.
+func mixRecording(dir string, tracks []RecordedTrack) error {
+	if len(tracks) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+	ffmpeg, ok := ffmpegPath()
+	if !ok {
+		return fmt.Errorf("ffmpeg not available")
+	}
+
+	args := []string{"-y", "-loglevel", "error"}
+	var filter strings.Builder
+	for i, t := range tracks {
+		args = append(args, "-i", filepath.Join(dir, t.File))
+		fmt.Fprintf(&filter, "[%d]adelay=delays=%d:all=1[a%d];", i, t.OffsetMs, i)
+	}
+	for i := range tracks {
+		fmt.Fprintf(&filter, "[a%d]", i)
+	}
+	fmt.Fprintf(&filter, "amix=inputs=%d:normalize=0[out]", len(tracks))
+
+	args = append(args,
+		"-filter_complex", filter.String(),
+		"-map", "[out]",
+		"-c:a", "libopus",
+		filepath.Join(dir, recordingMix),
+	)
+
+	if out, err := exec.Command(ffmpeg, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RecordingFiles lists the downloadable files of a recording: one per
+// participant track plus the mix when it exists.
+func RecordingFiles(recordingID string) []RecordedTrack {
+	dir := RecordingDir(recordingID)
+
+	var manifest struct {
+		Tracks []RecordedTrack `json:"tracks"`
+	}
+	if raw, err := os.ReadFile(filepath.Join(dir, recordingManifest)); err == nil {
+		_ = json.Unmarshal(raw, &manifest)
+	}
+
+	files := manifest.Tracks
+	if _, err := os.Stat(filepath.Join(dir, recordingMix)); err == nil {
+		files = append(files, RecordedTrack{File: recordingMix})
+	}
+	return files
+}
+
+func recordingState(room *Room) recordingView {
+	if room.recording == nil {
+		return recordingView{}
+	}
+	return recordingView{
+		Active:    true,
+		ID:        room.recording.id,
+		StartedAt: room.recording.startedAt.UnixMilli(),
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import "sync"
+
+// Lock order, outermost first: a room's lock, then the rooms or clients
+// registry, then a client's relayMu, then client.mu, then an outbox. The
+// registries and client.mu are only held briefly and never while taking a
+// room's lock.
+
+// roomRegistry maps room IDs to live rooms. Its lock covers the map only;
+// each room's state is guarded by the room's own lock.
+type roomRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]*Room
+}
+
+func (r *roomRegistry) get(roomID string) (*Room, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	room, ok := r.rooms[roomID]
+	return room, ok
+}
+
+// open publishes a new room unless one with its ID is already open, in
+// which case it returns that one and false. Callers lock the new room
+// first so nobody sees it before its room-opened event.
+// Opening also seeds the room's event numbering, since the room may have
+// been homed elsewhere since it was last open here.
+func (r *roomRegistry) open(room *Room) (*Room, bool) {
+	r.mu.Lock()
+	if existing, ok := r.rooms[room.ID]; ok {
+		r.mu.Unlock()
+		return existing, false
+	}
+	r.rooms[room.ID] = room
+	r.mu.Unlock()
+
+	openEventSeq(room.ID)
+	return room, true
+}
+
+// remove drops the room if it is still the one registered under its ID.
+func (r *roomRegistry) remove(room *Room) {
+	r.mu.Lock()
+	removed := r.rooms[room.ID] == room
+	if removed {
+		delete(r.rooms, room.ID)
+	}
+	r.mu.Unlock()
+
+	if removed {
+		closeEventSeq(room.ID)
+	}
+}
+
+// all returns the live rooms in no particular order.
+func (r *roomRegistry) all() []*Room {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make([]*Room, 0, len(r.rooms))
+	for _, room := range r.rooms {
+		all = append(all, room)
+	}
+	return all
+}
+
+// clientRegistry maps connection IDs to clients.
+type clientRegistry struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+func (r *clientRegistry) get(id string) (*Client, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	client, ok := r.clients[id]
+	return client, ok
+}
+
+// has reports whether client is still the connection registered under its
+// ID.
+func (r *clientRegistry) has(client *Client) bool {
+	current, ok := r.get(client.ID)
+	return ok && current == client
+}
+
+// add registers the client unless its ID is taken.
+func (r *clientRegistry) add(client *Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, taken := r.clients[client.ID]; taken {
+		return false
+	}
+	r.clients[client.ID] = client
+	return true
+}
+
+// remove unregisters the client if it is still the one under its ID.
+func (r *clientRegistry) remove(client *Client) {
+	r.mu.Lock()
+	if r.clients[client.ID] == client {
+		delete(r.clients, client.ID)
+	}
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) all() []*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		all = append(all, client)
+	}
+	return all
+}
+
+// lockRoom finds a room and takes its lock. It returns false, holding
+// nothing, if the room is not open.
+func lockRoom(roomID string) (*Room, bool) {
+	for {
+		room, ok := rooms.get(roomID)
+		if !ok {
+			return nil, false
+		}
+		room.mu.Lock()
+		// The room may have closed while we waited.
+		if current, ok := rooms.get(roomID); ok && current == room {
+			return room, true
+		}
+		room.mu.Unlock()
+	}
+}
+
+// lockClientRoom takes the lock of the room the client is in.
+func lockClientRoom(client *Client) (*Room, bool) {
+	for {
+		roomID := client.roomID()
+		room, ok := lockRoom(roomID)
+		if !ok {
+			return nil, false
+		}
+		if client.roomID() == roomID {
+			return room, true
+		}
+		room.mu.Unlock()
+	}
+}
+
+// roomID reads the client's room from outside that room's lock.
+func (c *Client) roomID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.RoomID
+}
+
+// A client's RoomID changes only under the lock of the room it leaves or
+// enters, and passes through "" in between, so holding the lock of the
+// client's room keeps RoomID still.
+
+// enterRoom sets the client's room unless it has been evicted. Caller must
+// hold the room's lock.
+func (c *Client) enterRoom(roomID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.evicted {
+		return false
+	}
+	c.RoomID = roomID
+	return true
+}
+
+// leaveRoom clears the client's room. Caller must hold the room's lock.
+func (c *Client) leaveRoom() {
+	c.mu.Lock()
+	c.RoomID = ""
+	c.mu.Unlock()
+}
+
+func (c *Client) isSuspended() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.suspended
+}
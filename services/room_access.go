@@ -0,0 +1,87 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+// roomTokenTTL is how long a room token stays valid after it was issued.
+func roomTokenTTL() time.Duration {
+	return time.Duration(envInt("ROOM_TOKEN_TTL_HOURS", 24)) * time.Hour
+}
+
+// RoomGrant is what a room token unlocks: what one session of a room
+// produced, from its room-opened event until the room was next opened.
+type RoomGrant struct {
+	RoomID  string
+	Session int64 // seq of the session's room-opened event
+	From    time.Time
+	To      time.Time // zero while no later session has opened
+	next    int64     // seq of the next session's room-opened event, or 0
+}
+
+// Covers reports whether something made at t belongs to the session.
+func (g RoomGrant) Covers(t time.Time) bool {
+	return !t.Before(g.From) && (g.To.IsZero() || t.Before(g.To))
+}
+
+// RoomAccessToken is the capability that unlocks a room session's archived
+// artifacts over REST. It is handed to the session's host in room-state,
+// so hosts without an account can still retrieve what their meeting
+// produced. Room IDs get reused, so it covers only the session it was
+// issued in, and it expires after ROOM_TOKEN_TTL_HOURS.
+func RoomAccessToken(roomID string, session int64, openedAt time.Time) string {
+	expires := time.Now().Add(roomTokenTTL()).Truncate(time.Minute)
+	claims := fmt.Sprintf("%d.%d.%d", session, openedAt.UnixMilli(), expires.Unix())
+	return claims + "." + roomTokenMAC(roomID, claims)
+}
+
+func roomTokenMAC(roomID, claims string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("room:" + roomID + ":" + claims))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRoomAccessToken checks a room token and returns the session it
+// unlocks.
+func VerifyRoomAccessToken(roomID, token string) (RoomGrant, bool) {
+	grant := RoomGrant{RoomID: roomID}
+	if os.Getenv("JWT_SECRET") == "" || token == "" {
+		return grant, false
+	}
+
+	cut := strings.LastIndex(token, ".")
+	if cut < 0 {
+		return grant, false
+	}
+	claims, sig := token[:cut], token[cut+1:]
+	if !hmac.Equal([]byte(roomTokenMAC(roomID, claims)), []byte(sig)) {
+		return grant, false
+	}
+
+	var openedMs, expires int64
+	if _, err := fmt.Sscanf(claims, "%d.%d.%d", &grant.Session, &openedMs, &expires); err != nil {
+		return grant, false
+	}
+	if time.Now().Unix() >= expires {
+		return grant, false
+	}
+
+	grant.From = time.UnixMilli(openedMs)
+	if config.DB != nil {
+		var next models.RoomEvent
+		if config.DB.Where("room_id = ? AND type = ? AND seq > ?", roomID, evRoomOpened, grant.Session).
+			Order("seq").Limit(1).Find(&next).RowsAffected > 0 {
+			grant.To, grant.next = next.At, next.Seq
+		}
+	}
+	return grant, true
+}
@@ -0,0 +1,567 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+// Room event types. Each one is a change to RoomState; see Apply.
+const (
+	evRoomOpened   = "room-opened"
+	evJoined       = "joined"
+	evLeft         = "left"
+	evHostAssigned = "host-assigned"
+	evRoomInfo     = "room-info-updated"
+	evTopology     = "topology-changed"
+	evVoteOpened   = "vote-opened"
+	evVoteCast     = "vote-cast"
+	evVoteClosed   = "vote-closed"
+	evStageMode    = "stage-mode"
+	evHand         = "hand"
+	evSpeaker      = "speaker"
+	evMediaShared  = "media-shared"
+	evMediaPinned  = "media-pinned"
+	evMediaRemoved = "media-removed"
+	evMediaCleared = "media-cleared"
+	evPresenting   = "presenting"
+	evPresentStop  = "presentation-stopped"
+)
+
+const (
+	eventQueueLen     = 4096
+	eventBatchSize    = 256
+	maxEventsPage     = 1000
+	defaultEventsPage = 500
+)
+
+// RoomState is the part of a room derived from its event log: who is
+// there, the host, room info, votes, stage roles, the gallery and the
+// presentation. It only changes through recordEvent, so replaying a room's
+// log rebuilds it exactly. Connections, media sessions, timers and
+// trackers stay on Room.
+type RoomState struct {
+	Participants []string             `json:"participants"`
+	HostID       string               `json:"hostId"`
+	Owner        string               `json:"owner,omitempty"`
+	Session      int64                `json:"session"`
+	OpenedAt     time.Time            `json:"openedAt"`
+	Info         models.Room          `json:"info"`
+	Topology     string               `json:"topology"`
+	ActiveVote   string               `json:"activeVote"`
+	CurrentVotes map[string]string    `json:"currentVotes"`
+	PastVotes    []PastVote           `json:"pastVotes"`
+	StageMode    bool                 `json:"stageMode"`
+	Speakers     map[string]bool      `json:"speakers"`
+	RaisedHands  []string             `json:"raisedHands"`
+	Gallery      []models.GalleryItem `json:"gallery"`
+	Presentation *presentation        `json:"presentation"`
+	// MediaCleared hides the latest item from room-state until the next
+	// share; the gallery keeps it.
+	MediaCleared bool `json:"mediaCleared"`
+}
+
+// roomInfo is room metadata as logged; models.Room hides Tags from JSON.
+type roomInfo struct {
+	Title  string `json:"title"`
+	Topic  string `json:"topic"`
+	Tags   string `json:"tags"`
+	Listed bool   `json:"listed"`
+}
+
+func roomInfoOf(info models.Room) roomInfo {
+	return roomInfo{Title: info.Title, Topic: info.Topic, Tags: info.Tags, Listed: info.Listed}
+}
+
+type roomOpenedEvent struct {
+	HostID    string               `json:"hostId"`
+	Owner     string               `json:"owner,omitempty"`
+	Info      roomInfo             `json:"info"`
+	CreatedAt time.Time            `json:"createdAt"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+	Gallery   []models.GalleryItem `json:"gallery"`
+}
+
+type voteCastEvent struct {
+	UserID string `json:"userId"`
+	Value  string `json:"value"`
+}
+
+type stageModeEvent struct {
+	Enabled  bool     `json:"enabled"`
+	Speakers []string `json:"speakers"`
+}
+
+type speakerEvent struct {
+	UserID  string `json:"userId"`
+	Speaker bool   `json:"speaker"`
+}
+
+type mediaPinnedEvent struct {
+	ItemID string `json:"itemId"`
+	Pinned bool   `json:"pinned"`
+}
+
+// Apply folds one event into the state. The live server and the replay
+// tool share it, so they cannot drift apart.
+func (s *RoomState) Apply(ev models.RoomEvent) error {
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(ev.Data, v); err != nil {
+			return fmt.Errorf("event %d (%s): %w", ev.Seq, ev.Type, err)
+		}
+		return nil
+	}
+
+	switch ev.Type {
+	case evRoomOpened:
+		var d roomOpenedEvent
+		if err := decode(&d); err != nil {
+			return err
+		}
+		gallery := d.Gallery
+		if gallery == nil {
+			gallery = []models.GalleryItem{}
+		}
+		*s = RoomState{
+			Participants: []string{},
+			HostID:       d.HostID,
+			Owner:        d.Owner,
+			Session:      ev.Seq,
+			OpenedAt:     ev.At,
+			Info: models.Room{
+				ID:        ev.RoomID,
+				HostID:    d.HostID,
+				Title:     d.Info.Title,
+				Topic:     d.Info.Topic,
+				Tags:      d.Info.Tags,
+				Listed:    d.Info.Listed,
+				CreatedAt: d.CreatedAt,
+				UpdatedAt: d.UpdatedAt,
+			},
+			Topology:     topologyMesh,
+			CurrentVotes: map[string]string{},
+			PastVotes:    []PastVote{},
+			Speakers:     map[string]bool{},
+			Gallery:      gallery,
+		}
+
+	case evJoined:
+		s.Participants = append(removeString(s.Participants, ev.Actor), ev.Actor)
+
+	case evLeft:
+		s.Participants = removeString(s.Participants, ev.Actor)
+		s.RaisedHands = removeString(s.RaisedHands, ev.Actor)
+
+	case evHostAssigned:
+		s.HostID = ev.Actor
+
+	case evRoomInfo:
+		var d roomInfo
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.Info.ID = ev.RoomID
+		s.Info.HostID = s.HostID
+		s.Info.Title, s.Info.Topic, s.Info.Tags, s.Info.Listed = d.Title, d.Topic, d.Tags, d.Listed
+		if s.Info.CreatedAt.IsZero() {
+			s.Info.CreatedAt = ev.At
+		}
+		s.Info.UpdatedAt = ev.At
+
+	case evTopology:
+		var d struct {
+			Topology string `json:"topology"`
+		}
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.Topology = d.Topology
+
+	case evVoteOpened:
+		var d struct {
+			Question string `json:"question"`
+		}
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.ActiveVote = d.Question
+		s.CurrentVotes = map[string]string{}
+
+	case evVoteCast:
+		var d voteCastEvent
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.CurrentVotes[d.UserID] = d.Value
+
+	case evVoteClosed:
+		if s.ActiveVote != "" {
+			vote := PastVote{Question: s.ActiveVote, TotalVotes: len(s.CurrentVotes)}
+			for _, v := range s.CurrentVotes {
+				if v == "yes" {
+					vote.YesCount++
+				} else if v == "no" {
+					vote.NoCount++
+				}
+			}
+			s.PastVotes = append(s.PastVotes, vote)
+		}
+		s.ActiveVote = ""
+		s.CurrentVotes = map[string]string{}
+
+	case evStageMode:
+		var d stageModeEvent
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.StageMode = d.Enabled
+		s.Speakers = map[string]bool{s.HostID: true}
+		for _, id := range d.Speakers {
+			s.Speakers[id] = true
+		}
+		s.RaisedHands = nil
+
+	case evHand:
+		var d struct {
+			Raised bool `json:"raised"`
+		}
+		if err := decode(&d); err != nil {
+			return err
+		}
+		s.RaisedHands = removeString(s.RaisedHands, ev.Actor)
+		if d.Raised && !(s.StageMode && s.Speakers[ev.Actor]) {
+			s.RaisedHands = append(s.RaisedHands, ev.Actor)
+		}
+
+	case evSpeaker:
+		var d speakerEvent
+		if err := decode(&d); err != nil {
+			return err
+		}
+		if d.Speaker {
+			s.Speakers[d.UserID] = true
+			s.RaisedHands = removeString(s.RaisedHands, d.UserID)
+		} else {
+			delete(s.Speakers, d.UserID)
+		}
+
+	case evMediaShared:
+		var item models.GalleryItem
+		if err := decode(&item); err != nil {
+			return err
+		}
+		s.Gallery = append(s.Gallery, item)
+		s.MediaCleared = false
+
+	case evMediaCleared:
+		s.MediaCleared = true
+
+	case evMediaPinned:
+		var d mediaPinnedEvent
+		if err := decode(&d); err != nil {
+			return err
+		}
+		for i := range s.Gallery {
+			if s.Gallery[i].ID == d.ItemID {
+				s.Gallery[i].Pinned = d.Pinned
+			}
+		}
+
+	case evMediaRemoved:
+		var d struct {
+			ItemID string `json:"itemId"`
+		}
+		if err := decode(&d); err != nil {
+			return err
+		}
+		for i, item := range s.Gallery {
+			if item.ID == d.ItemID {
+				s.Gallery = append(s.Gallery[:i], s.Gallery[i+1:]...)
+				break
+			}
+		}
+		if s.Presentation != nil && s.Presentation.DocumentID == d.ItemID {
+			s.Presentation = nil
+		}
+
+	case evPresenting:
+		var p presentation
+		if err := decode(&p); err != nil {
+			return err
+		}
+		s.Presentation = &p
+
+	case evPresentStop:
+		s.Presentation = nil
+
+	default:
+		return fmt.Errorf("event %d: unknown type %q", ev.Seq, ev.Type)
+	}
+	return nil
+}
+
+// recordEvent appends an event to the room's log and applies it to the
+// room's state. Caller must hold the room's lock.
+func recordEvent(room *Room, kind, actor string, data interface{}) {
+	raw := json.RawMessage("{}")
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("❌ Failed to encode %s event for room %s: %v", kind, room.ID, err)
+			return
+		}
+		raw = b
+	}
+
+	ev := models.RoomEvent{
+		RoomID: room.ID,
+		Seq:    nextEventSeq(room.ID),
+		Type:   kind,
+		Actor:  actor,
+		Data:   raw,
+		At:     time.Now().UTC(),
+	}
+	if err := room.RoomState.Apply(ev); err != nil {
+		log.Printf("❌ Room %s: %v", room.ID, err)
+	}
+	room.recent = append(room.recent, ev)
+	if len(room.recent) > resumeBacklog {
+		room.recent = append([]models.RoomEvent(nil), room.recent[len(room.recent)-resumeBacklog:]...)
+	}
+	persistEvent(ev)
+}
+
+// eventSeqs numbers the events of the rooms homed here. A room is seeded
+// from the database whenever it opens on this instance, since while it
+// was homed elsewhere another instance went on numbering it. An entry
+// outlives its room only until the room's queued events are written, so
+// a room reopened meanwhile, such as a recreated breakout, continues
+// after them.
+var (
+	eventSeqsMu sync.Mutex
+	eventSeqs   = make(map[string]*roomSeq)
+)
+
+type roomSeq struct {
+	last   int64
+	queued int
+	open   bool
+}
+
+// openEventSeq seeds a room's numbering as it opens here, after the later
+// of what the database holds and what this instance still has queued.
+// Caller must hold the room's lock.
+func openEventSeq(roomID string) {
+	var stored int64
+	if config.DB != nil {
+		config.DB.Model(&models.RoomEvent{}).Where("room_id = ?", roomID).
+			Select("COALESCE(MAX(seq), 0)").Scan(&stored)
+	}
+
+	eventSeqsMu.Lock()
+	defer eventSeqsMu.Unlock()
+	seq, ok := eventSeqs[roomID]
+	if !ok {
+		seq = &roomSeq{}
+		eventSeqs[roomID] = seq
+	}
+	seq.last = max(seq.last, stored)
+	seq.open = true
+}
+
+// closeEventSeq forgets a room that closed here once nothing of it is
+// left to write.
+func closeEventSeq(roomID string) {
+	eventSeqsMu.Lock()
+	defer eventSeqsMu.Unlock()
+	if seq, ok := eventSeqs[roomID]; ok {
+		seq.open = false
+		if seq.queued == 0 {
+			delete(eventSeqs, roomID)
+		}
+	}
+}
+
+// nextEventSeq continues a room's numbering. Caller must hold the room's
+// lock.
+func nextEventSeq(roomID string) int64 {
+	eventSeqsMu.Lock()
+	_, ok := eventSeqs[roomID]
+	eventSeqsMu.Unlock()
+	if !ok {
+		openEventSeq(roomID)
+	}
+
+	eventSeqsMu.Lock()
+	defer eventSeqsMu.Unlock()
+	seq := eventSeqs[roomID]
+	seq.last++
+	if config.DB != nil {
+		seq.queued++
+	}
+	return seq.last
+}
+
+// lastEventSeq is the sequence number of the room's latest event.
+func lastEventSeq(roomID string) int64 {
+	eventSeqsMu.Lock()
+	defer eventSeqsMu.Unlock()
+	if seq, ok := eventSeqs[roomID]; ok {
+		return seq.last
+	}
+	return 0
+}
+
+// eventsWritten settles a batch the writer is done with, dropping closed
+// rooms that have nothing left queued.
+func eventsWritten(batch []models.RoomEvent) {
+	eventSeqsMu.Lock()
+	defer eventSeqsMu.Unlock()
+	for _, ev := range batch {
+		seq, ok := eventSeqs[ev.RoomID]
+		if !ok {
+			continue
+		}
+		seq.queued--
+		if seq.queued <= 0 && !seq.open {
+			delete(eventSeqs, ev.RoomID)
+		}
+	}
+}
+
+var (
+	eventQueue      chan models.RoomEvent
+	eventWriterOnce sync.Once
+)
+
+// persistEvent hands the event to the background writer so the database
+// never stalls signaling. If the writer is far behind, the event is saved
+// inline rather than dropped.
+func persistEvent(ev models.RoomEvent) {
+	if config.DB == nil {
+		return
+	}
+	eventWriterOnce.Do(func() {
+		eventQueue = make(chan models.RoomEvent, eventQueueLen)
+		go writeEvents(eventQueue)
+	})
+	select {
+	case eventQueue <- ev:
+	default:
+		saveEvents([]models.RoomEvent{ev})
+	}
+}
+
+func writeEvents(queue chan models.RoomEvent) {
+	for ev := range queue {
+		batch := []models.RoomEvent{ev}
+	drain:
+		for len(batch) < eventBatchSize {
+			select {
+			case next := <-queue:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		saveEvents(batch)
+	}
+}
+
+func saveEvents(batch []models.RoomEvent) {
+	if err := config.DB.Create(&batch).Error; err != nil {
+		log.Printf("❌ Failed to persist %d room events: %v", len(batch), err)
+	}
+	eventsWritten(batch)
+}
+
+// RoomEvents returns up to limit events of a room after seq since, oldest
+// first.
+func RoomEvents(roomID string, since int64, limit int) []models.RoomEvent {
+	if limit <= 0 {
+		limit = defaultEventsPage
+	}
+	if limit > maxEventsPage {
+		limit = maxEventsPage
+	}
+
+	events := []models.RoomEvent{}
+	if config.DB != nil {
+		config.DB.Where("room_id = ? AND seq > ?", roomID, since).
+			Order("seq").Limit(limit).Find(&events)
+	}
+	return events
+}
+
+// SessionEvents is RoomEvents confined to the session a room token grants.
+func SessionEvents(grant RoomGrant, since int64, limit int) []models.RoomEvent {
+	if limit <= 0 {
+		limit = defaultEventsPage
+	}
+	if limit > maxEventsPage {
+		limit = maxEventsPage
+	}
+
+	events := []models.RoomEvent{}
+	if config.DB != nil {
+		q := config.DB.Where("room_id = ? AND seq > ? AND seq >= ?", grant.RoomID, since, grant.Session)
+		if grant.next > 0 {
+			q = q.Where("seq < ?", grant.next)
+		}
+		q.Order("seq").Limit(limit).Find(&events)
+	}
+	return events
+}
+
+// ReplayRoom rebuilds a room's state from its persisted log, stopping
+// after event seq upTo (0 for no limit) or the last event at or before
+// until (zero for no limit). It returns the state and the last event
+// applied.
+func ReplayRoom(roomID string, upTo int64, until time.Time) (RoomState, int64, error) {
+	var state RoomState
+	if config.DB == nil {
+		return state, 0, fmt.Errorf("database not initialized")
+	}
+
+	q := config.DB.Where("room_id = ?", roomID)
+	if upTo > 0 {
+		q = q.Where("seq <= ?", upTo)
+	}
+	if !until.IsZero() {
+		q = q.Where("at <= ?", until.UTC())
+	}
+
+	var events []models.RoomEvent
+	if err := q.Order("seq").Find(&events).Error; err != nil {
+		return state, 0, err
+	}
+	if len(events) == 0 {
+		return state, 0, fmt.Errorf("no events for room %s", roomID)
+	}
+
+	for _, ev := range events {
+		if err := state.Apply(ev); err != nil {
+			return state, ev.Seq, err
+		}
+	}
+	return state, events[len(events)-1].Seq, nil
+}
+
+// DescribeEvent is a one-line summary for logs and the replay tool.
+func DescribeEvent(ev models.RoomEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s %s", ev.Seq, ev.At.Format(time.RFC3339), ev.Type)
+	if ev.Actor != "" {
+		fmt.Fprintf(&b, " by %s", ev.Actor)
+	}
+	if len(ev.Data) > 0 && string(ev.Data) != "{}" {
+		fmt.Fprintf(&b, " %s", ev.Data)
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+func TestEventSeqReseedsWhenRoomReopens(t *testing.T) {
+	host := connectPeer(t, "seq-host")
+	host.join("seq-room", true)
+	first := lastEventSeq("seq-room")
+
+	// The room closes here and moves to another instance, which goes on
+	// numbering it.
+	host.send(map[string]interface{}{"type": "leave"})
+	room, _ := rooms.get("seq-room")
+	room.mu.Lock()
+	rooms.remove(room)
+	room.mu.Unlock()
+	waitUntil(t, "the closed room's numbering is dropped", func() bool {
+		eventSeqsMu.Lock()
+		defer eventSeqsMu.Unlock()
+		_, ok := eventSeqs["seq-room"]
+		return !ok
+	})
+	elsewhere := first + 50
+	config.DB.Create(&models.RoomEvent{RoomID: "seq-room", Seq: elsewhere, Type: evJoined, Data: json.RawMessage("{}"), At: time.Now().UTC()})
+
+	host.reconnect()
+	host.join("seq-room", true)
+	room, _ = rooms.get("seq-room")
+	room.mu.Lock()
+	opened := room.recent[0]
+	room.mu.Unlock()
+	if opened.Type != evRoomOpened || opened.Seq != elsewhere+1 {
+		t.Fatalf("the reopened room's first event is %s #%d, want %s #%d", opened.Type, opened.Seq, evRoomOpened, elsewhere+1)
+	}
+}
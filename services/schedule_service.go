@@ -0,0 +1,151 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+// Guests may enter a scheduled room this long before its start time, even
+// if the creator has not connected yet.
+const scheduledRoomEarlyJoin = 15 * time.Minute
+
+const icsTimeFormat = "20060102T150405Z"
+
+// scheduledRoomOwner reports whether a scheduled session for roomID is
+// currently running or about to start, and who scheduled it.
+func scheduledRoomOwner(roomID string, now time.Time) (string, bool) {
+	if config.DB == nil {
+		return "", false
+	}
+
+	var schedules []models.ScheduledRoom
+	config.DB.Where("room_id = ? AND start_time <= ?", roomID, now.Add(scheduledRoomEarlyJoin)).Order("start_time").Find(&schedules)
+
+	for _, s := range schedules {
+		if now.Before(s.EndTime()) {
+			return s.CreatedBy, true
+		}
+	}
+	return "", false
+}
+
+var (
+	ErrRoomScheduledByOther = errors.New("this room is scheduled by someone else")
+	ErrScheduleOverlap      = errors.New("this room is already scheduled at that time")
+)
+
+// SchedulesRoom reports whether username has scheduled roomID before. A
+// schedule makes its creator the room's host, so only they may reuse it.
+func SchedulesRoom(username, roomID string) bool {
+	var count int64
+	config.DB.Model(&models.ScheduledRoom{}).Where("room_id = ? AND created_by = ?", roomID, username).Count(&count)
+	return count > 0
+}
+
+// CheckSchedule checks a new or changed schedule against the room's other
+// sessions: all of them belong to one organizer and none overlap.
+func CheckSchedule(s models.ScheduledRoom) error {
+	var others []models.ScheduledRoom
+	config.DB.Where("room_id = ? AND id <> ?", s.RoomID, s.ID).Find(&others)
+
+	for _, other := range others {
+		if other.CreatedBy != s.CreatedBy {
+			return ErrRoomScheduledByOther
+		}
+		if other.StartTime.Before(s.EndTime()) && s.StartTime.Before(other.EndTime()) {
+			return ErrScheduleOverlap
+		}
+	}
+	return nil
+}
+
+// CalendarFeedToken derives the secret that authorizes a user's .ics feed.
+// Calendar apps cannot send an Authorization header, so the token travels
+// in the subscription URL instead.
+func CalendarFeedToken(username string) (string, error) {
+	secretKey := os.Getenv("JWT_SECRET")
+	if secretKey == "" {
+		return "", fmt.Errorf("JWT_SECRET is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte("calendar:" + username))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func VerifyCalendarFeedToken(username, token string) bool {
+	expected, err := CalendarFeedToken(username)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+// BuildICS renders scheduled rooms as an RFC 5545 calendar.
+func BuildICS(name string, schedules []models.ScheduledRoom) string {
+	frontendURL := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/")
+
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//AgoraNet//Scheduled Rooms//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:"+escapeICSText(name))
+
+	for _, s := range schedules {
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+s.ID+"@agoranet")
+		writeICSLine(&b, "DTSTAMP:"+s.UpdatedAt.UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "DTSTART:"+s.StartTime.UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "DTEND:"+s.EndTime().UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(s.Title))
+		if s.Description != "" {
+			writeICSLine(&b, "DESCRIPTION:"+escapeICSText(s.Description))
+		}
+		if frontendURL != "" {
+			writeICSLine(&b, "URL:"+frontendURL+"/rooms/"+s.RoomID)
+		}
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func escapeICSText(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return s
+}
+
+// writeICSLine folds content lines at 75 octets without splitting UTF-8
+// sequences, as required by RFC 5545 section 3.1.
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines spend one octet on the leading space.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
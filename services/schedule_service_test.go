@@ -0,0 +1,37 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+	"github.com/nbursa/agoranet/models"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	start := time.Date(2026, 11, 2, 10, 0, 0, 0, time.UTC)
+	config.DB.Create(&models.ScheduledRoom{ID: "s1", RoomID: "weekly", Title: "Weekly", StartTime: start, DurationMinutes: 60, CreatedBy: "alice"})
+
+	if !SchedulesRoom("alice", "weekly") || SchedulesRoom("mallory", "weekly") {
+		t.Fatal("SchedulesRoom does not follow who scheduled the room")
+	}
+
+	cases := []struct {
+		name     string
+		schedule models.ScheduledRoom
+		want     error
+	}{
+		{"next week", models.ScheduledRoom{ID: "s2", RoomID: "weekly", StartTime: start.Add(7 * 24 * time.Hour), DurationMinutes: 60, CreatedBy: "alice"}, nil},
+		{"right after", models.ScheduledRoom{ID: "s2", RoomID: "weekly", StartTime: start.Add(time.Hour), DurationMinutes: 30, CreatedBy: "alice"}, nil},
+		{"overlapping", models.ScheduledRoom{ID: "s2", RoomID: "weekly", StartTime: start.Add(30 * time.Minute), DurationMinutes: 60, CreatedBy: "alice"}, ErrScheduleOverlap},
+		{"inside", models.ScheduledRoom{ID: "s2", RoomID: "weekly", StartTime: start.Add(10 * time.Minute), DurationMinutes: 10, CreatedBy: "alice"}, ErrScheduleOverlap},
+		{"someone else", models.ScheduledRoom{ID: "s2", RoomID: "weekly", StartTime: start.Add(7 * 24 * time.Hour), DurationMinutes: 60, CreatedBy: "mallory"}, ErrRoomScheduledByOther},
+		{"itself, moved", models.ScheduledRoom{ID: "s1", RoomID: "weekly", StartTime: start.Add(15 * time.Minute), DurationMinutes: 60, CreatedBy: "alice"}, nil},
+	}
+	for _, c := range cases {
+		if err := CheckSchedule(c.schedule); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
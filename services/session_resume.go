@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"time"
+
+	ws "github.com/gofiber/websocket/v2"
+
+	"github.com/nbursa/agoranet/models"
+)
+
+// Rooms keep this many recent events in memory for resuming clients; a
+// client further behind gets a full room-state instead.
+const resumeBacklog = 256
+
+// resumeGrace is how long a dropped client keeps its slot and room
+// membership. RESUME_GRACE_SECONDS=0 evicts immediately, as before.
+func resumeGrace() time.Duration {
+	return time.Duration(envInt("RESUME_GRACE_SECONDS", 30)) * time.Second
+}
+
+func newResumeToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("❌ Failed to generate resume token: %v", err)
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// resumeSession moves a client onto a new connection, whose outbox is out,
+// when the init message carries its current resume token. The client gets a fresh token, the room
+// events it missed after lastSeq, and the current room-state. It returns
+// nil when there is nothing to resume.
+func resumeSession(out *outbox, userID, token string, lastSeq int64, version int) *Client {
+	if userID == "" || token == "" {
+		return nil
+	}
+
+	client, ok := clients.get(userID)
+	if !ok {
+		return nil
+	}
+	// Deferred first, so the relay goes out after the room's lock is
+	// released too.
+	defer client.flushRelays()
+	room, inRoom := lockClientRoom(client)
+	if inRoom {
+		defer room.mu.Unlock()
+	}
+
+	client.mu.Lock()
+	if client.evicted || client.resumeToken == "" ||
+		subtle.ConstantTimeCompare([]byte(client.resumeToken), []byte(token)) != 1 {
+		client.mu.Unlock()
+		return nil
+	}
+	if client.graceTimer != nil {
+		client.graceTimer.Stop()
+		client.graceTimer = nil
+	}
+	wasSuspended := client.suspended
+	client.suspended = false
+	client.resumeToken = newResumeToken()
+	resumeToken := client.resumeToken
+
+	out.bind(client.ID)
+	old := client.out
+	client.Conn = out.conn
+	client.out = out
+	// Queued under client.mu so the home sees it after any drop.
+	if client.home != "" {
+		client.queueRelay(client.home, relayEnvelope{Op: opResume, Conn: client.ID, Link: client.link, LastSeq: lastSeq})
+	}
+	client.mu.Unlock()
+
+	// The old connection may not have noticed the drop yet.
+	old.shutdown()
+
+	client.send(&outInit{
+		messageType: typed("init"),
+		UserID:      client.ID,
+		Identity:    client.UserID,
+		ResumeToken: resumeToken,
+		Resumed:     true,
+		Protocol:    version,
+	})
+	log.Printf("🔄 Resumed session for %s", client.ID)
+
+	if inRoom && room.Clients[client.ID] == client {
+		catchUp(room, client, lastSeq, wasSuspended)
+	}
+	return client
+}
+
+// catchUp sends a resumed client the room events it missed after lastSeq
+// and the current room-state, and tells its peers it is back. Caller must
+// hold the room's lock.
+func catchUp(room *Room, client *Client, lastSeq int64, wasSuspended bool) {
+	events, complete := missedEvents(room, lastSeq)
+	client.send(&outResumed{messageType: typed("resumed"), RoomID: room.ID, Events: events, Complete: complete})
+	sendRoomStateTo(room.ID, client)
+	sendGallery(room, client)
+
+	if wasSuspended {
+		broadcastMessage(room.ID, &userMessage{messageType: typed("peer-resumed"), UserID: client.ID})
+	}
+}
+
+// missedEvents returns the room's events after seq from the in-memory
+// backlog. complete is false when the backlog no longer reaches back that
+// far. Caller must hold the room's lock.
+func missedEvents(room *Room, seq int64) ([]models.RoomEvent, bool) {
+	events := []models.RoomEvent{}
+	for _, ev := range room.recent {
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	complete := len(room.recent) > 0 && room.recent[0].Seq <= seq+1
+	if len(events) == 0 {
+		complete = true
+	}
+	return events, complete
+}
+
+// dropConnection handles a closed socket. A client in a room is suspended
+// for the grace period instead of leaving, so it can resume. Nothing
+// happens if the client already resumed on another connection or left.
+func dropConnection(client *Client, conn *ws.Conn) {
+	defer client.flushRelays()
+	room, inRoom := lockClientRoom(client)
+	if inRoom {
+		defer room.mu.Unlock()
+	}
+
+	client.mu.Lock()
+	current := client.Conn == conn
+	bound := client.home != ""
+	client.mu.Unlock()
+	if !clients.has(client) || !current {
+		return
+	}
+
+	grace := resumeGrace()
+	if grace <= 0 || !bound && (!inRoom || room.Clients[client.ID] != client) {
+		evictClient(client)
+		log.Println("❌ Disconnected:", client.ID)
+		return
+	}
+
+	client.mu.Lock()
+	client.suspended = true
+	client.graceTimer = time.AfterFunc(grace, func() {
+		room, inRoom := lockClientRoom(client)
+		if inRoom {
+			defer room.mu.Unlock()
+		}
+		if client.isSuspended() && clients.has(client) {
+			evictClient(client)
+			log.Printf("⌛ Resume grace expired for %s", client.ID)
+		}
+	})
+	// The room's home suspends its proxy and tells the peers.
+	if bound {
+		client.queueRelay(client.home, relayEnvelope{Op: opDrop, Conn: client.ID, Link: client.link})
+	}
+	client.mu.Unlock()
+
+	if bound {
+		log.Printf("📴 %s dropped; holding its place for %v", client.ID, grace)
+		return
+	}
+
+	if room.Activity.speaking(client.ID, false, time.Now()) {
+		broadcastMessage(room.ID, &outSpeaking{messageType: typed("speaking"), UserID: client.ID})
+	}
+	broadcastMessage(room.ID, &userMessage{messageType: typed("peer-reconnecting"), UserID: client.ID})
+	log.Printf("📴 %s dropped; holding its place for %v", client.ID, grace)
+}
+
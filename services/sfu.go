@@ -0,0 +1,467 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	topologyMesh = "mesh"
+	topologySFU  = "sfu"
+)
+
+var (
+	sfuAPIOnce sync.Once
+	sfuAPI     *webrtc.API
+)
+
+// sfuWebRTC lazily builds the pion API shared by every SFU session.
+// SFU_PUBLIC_IP and SFU_UDP_PORT_MIN/MAX let the server sit behind NAT with
+// a fixed firewall range.
+func sfuWebRTC() *webrtc.API {
+	sfuAPIOnce.Do(func() {
+		media := &webrtc.MediaEngine{}
+		if err := media.RegisterDefaultCodecs(); err != nil {
+			log.Fatalf("❌ SFU codec registration failed: %v", err)
+		}
+
+		registry := &interceptor.Registry{}
+		if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+			log.Fatalf("❌ SFU interceptor registration failed: %v", err)
+		}
+
+		settings := webrtc.SettingEngine{}
+		if ip := os.Getenv("SFU_PUBLIC_IP"); ip != "" {
+			settings.SetNAT1To1IPs([]string{ip}, webrtc.ICECandidateTypeHost)
+		}
+		minPort, maxPort := envInt("SFU_UDP_PORT_MIN", 0), envInt("SFU_UDP_PORT_MAX", 0)
+		if minPort > 0 && maxPort >= minPort {
+			if err := settings.SetEphemeralUDPPortRange(uint16(minPort), uint16(maxPort)); err != nil {
+				log.Printf("⚠️ Ignoring SFU UDP port range: %v", err)
+			}
+		}
+
+		sfuAPI = webrtc.NewAPI(
+			webrtc.WithMediaEngine(media),
+			webrtc.WithInterceptorRegistry(registry),
+			webrtc.WithSettingEngine(settings),
+		)
+	})
+	return sfuAPI
+}
+
+func sfuICEServers() []webrtc.ICEServer {
+	urls := envList("SFU_ICE_SERVERS", []string{"stun:stun.l.google.com:19302"})
+	return []webrtc.ICEServer{{URLs: urls}}
+}
+
+// sfuSession is the server-side forwarding state for one room running in
+// SFU topology. Every participant holds a single PeerConnection to the
+// server, and each audio track published there is fanned out to the others.
+type sfuSession struct {
+	roomID string
+
+	mu     sync.Mutex
+	peers  map[string]*sfuPeer
+	tracks map[string]*sfuTrack
+	closed bool
+
+	// Only tracks of permitted publishers are fanned out: allowed limits
+	// publishing to a set (nil means everyone), blocked mutes individuals.
+	allowed map[string]bool
+	blocked map[string]bool
+
+	// recorder and livestream are read on every forwarded packet, hence
+	// atomic.
+	recorder   atomic.Pointer[roomRecorder]
+	livestream atomic.Pointer[roomLivestream]
+}
+
+type sfuPeer struct {
+	client *Client
+	pc     *webrtc.PeerConnection
+
+	// pendingOffer is set when tracks changed mid-negotiation; the offer is
+	// retried once the current answer arrives.
+	pendingOffer bool
+
+	// Gathering starts with SetLocalDescription, before the first offer is
+	// queued, so candidates wait until it is; a browser cannot add them
+	// before it has the offer.
+	candidateMu sync.Mutex
+	offered     bool
+	candidates  []webrtc.ICECandidateInit
+}
+
+// sendCandidate passes a gathered candidate to the client, holding it back
+// until the first offer has gone out.
+func (p *sfuPeer) sendCandidate(candidate webrtc.ICECandidateInit) {
+	p.candidateMu.Lock()
+	defer p.candidateMu.Unlock()
+	if !p.offered {
+		p.candidates = append(p.candidates, candidate)
+		return
+	}
+	p.client.send(&sfuCandidateMessage{messageType: typed("sfu-ice-candidate"), Candidate: candidateOf(candidate)})
+}
+
+// sendOffer queues an offer and then any candidates held back for it.
+func (p *sfuPeer) sendOffer(offer webrtc.SessionDescription) {
+	p.candidateMu.Lock()
+	defer p.candidateMu.Unlock()
+	p.client.send(&outSFUOffer{messageType: typed("sfu-offer"), Offer: descriptionOf(offer)})
+	if p.offered {
+		return
+	}
+	p.offered = true
+	for _, candidate := range p.candidates {
+		p.client.send(&sfuCandidateMessage{messageType: typed("sfu-ice-candidate"), Candidate: candidateOf(candidate)})
+	}
+	p.candidates = nil
+}
+
+type sfuTrack struct {
+	ownerID string
+	local   *webrtc.TrackLocalStaticRTP
+
+	// live is false while the owner may not publish, e.g. a stage listener
+	// or a muted speaker.
+	live atomic.Bool
+}
+
+func newSFUSession(roomID string) *sfuSession {
+	return &sfuSession{
+		roomID: roomID,
+		peers:  make(map[string]*sfuPeer),
+		tracks: make(map[string]*sfuTrack),
+	}
+}
+
+// roomSFU returns the room's SFU session, starting one if the room runs in
+// SFU topology or is migrating towards it. Caller must hold the room's lock.
+func roomSFU(room *Room, roomID string) *sfuSession {
+	migratingToSFU := room.migration != nil && room.migration.target == topologySFU
+	if room.Topology != topologySFU && !migratingToSFU {
+		return nil
+	}
+	if room.sfu == nil {
+		room.sfu = newSFUSession(roomID)
+		room.sfu.allowed, room.sfu.blocked = publishers(room)
+		log.Printf("🛰️ Started SFU session for room %s", roomID)
+	}
+	return room.sfu
+}
+
+// join creates the client's server-side PeerConnection and sends the first
+// offer. The server is always the offerer, so renegotiation after track
+// changes never races with the browser.
+func (s *sfuSession) join(client *Client) error {
+	pc, err := sfuWebRTC().NewPeerConnection(webrtc.Configuration{ICEServers: sfuICEServers()})
+	if err != nil {
+		return err
+	}
+
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		_ = pc.Close()
+		return err
+	}
+
+	peer := &sfuPeer{client: client, pc: pc}
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		peer.sendCandidate(c.ToJSON())
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			log.Printf("❌ SFU connection failed for %s in room %s", client.ID, s.roomID)
+			s.drop(client.ID, pc)
+		case webrtc.PeerConnectionStateClosed:
+			s.drop(client.ID, pc)
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.forward(client.ID, remote)
+	})
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = pc.Close()
+		return errors.New("sfu session closed")
+	}
+	old := s.peers[client.ID]
+	s.peers[client.ID] = peer
+	s.mu.Unlock()
+
+	if old != nil {
+		_ = old.pc.Close()
+	}
+
+	log.Printf("🛰️ %s joined SFU for room %s", client.ID, s.roomID)
+	s.renegotiateAll()
+	return nil
+}
+
+// forward republishes a participant's incoming track to the rest of the room.
+func (s *sfuSession) forward(ownerID string, remote *webrtc.TrackRemote) {
+	// The stream ID carries the owner so clients can map tracks to people;
+	// the track ID is unique so a rejoin never collides with the old track.
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, uuid.New().String(), ownerID)
+	if err != nil {
+		log.Printf("❌ SFU could not create local track for %s: %v", ownerID, err)
+		return
+	}
+
+	track := &sfuTrack{ownerID: ownerID, local: local}
+
+	s.mu.Lock()
+	track.live.Store(s.canPublish(ownerID))
+	s.tracks[local.ID()] = track
+	s.mu.Unlock()
+	s.renegotiateAll()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.tracks, local.ID())
+		s.mu.Unlock()
+		s.renegotiateAll()
+	}()
+
+	for {
+		pkt, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		if !track.live.Load() {
+			continue
+		}
+		if rec := s.recorder.Load(); rec != nil {
+			rec.write(local.ID(), ownerID, pkt)
+		}
+		if ls := s.livestream.Load(); ls != nil {
+			ls.write(local.ID(), pkt)
+		}
+		if err := local.WriteRTP(pkt); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// canPublish must be called with s.mu held.
+func (s *sfuSession) canPublish(ownerID string) bool {
+	return !s.blocked[ownerID] && (s.allowed == nil || s.allowed[ownerID])
+}
+
+// setPublishers updates who may publish and renegotiates everyone so
+// listeners gain or lose the affected tracks.
+func (s *sfuSession) setPublishers(allowed, blocked map[string]bool) {
+	s.mu.Lock()
+	s.allowed = allowed
+	s.blocked = blocked
+	for _, track := range s.tracks {
+		track.live.Store(s.canPublish(track.ownerID))
+	}
+	s.mu.Unlock()
+
+	s.renegotiateAll()
+}
+
+// renegotiateAll brings every peer's senders in line with the published
+// tracks and sends a fresh offer where anything changed.
+func (s *sfuSession) renegotiateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, peer := range s.peers {
+		s.negotiate(peer)
+	}
+}
+
+// negotiate must be called with s.mu held.
+func (s *sfuSession) negotiate(peer *sfuPeer) {
+	if peer.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+
+	// A peer that has never been offered, or is owed a retry, always gets one.
+	changed := peer.pc.LocalDescription() == nil || peer.pendingOffer
+
+	existing := map[string]bool{}
+	for _, sender := range peer.pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		existing[track.ID()] = true
+		if t, ok := s.tracks[track.ID()]; !ok || !t.live.Load() {
+			if err := peer.pc.RemoveTrack(sender); err != nil {
+				log.Printf("⚠️ SFU could not remove track %s for %s: %v", track.ID(), peer.client.ID, err)
+			}
+			changed = true
+		}
+	}
+
+	for id, track := range s.tracks {
+		if existing[id] || track.ownerID == peer.client.ID || !track.live.Load() {
+			continue
+		}
+		if _, err := peer.pc.AddTrack(track.local); err != nil {
+			log.Printf("⚠️ SFU could not add track %s for %s: %v", id, peer.client.ID, err)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return
+	}
+	if peer.pc.SignalingState() != webrtc.SignalingStateStable {
+		peer.pendingOffer = true
+		return
+	}
+	peer.pendingOffer = false
+
+	offer, err := peer.pc.CreateOffer(nil)
+	if err != nil {
+		log.Printf("❌ SFU offer failed for %s: %v", peer.client.ID, err)
+		return
+	}
+	if err := peer.pc.SetLocalDescription(offer); err != nil {
+		log.Printf("❌ SFU could not set local description for %s: %v", peer.client.ID, err)
+		return
+	}
+
+	peer.sendOffer(offer)
+}
+
+func (s *sfuSession) answer(clientID string, answer webrtc.SessionDescription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, ok := s.peers[clientID]
+	if !ok {
+		return errors.New("not connected to sfu")
+	}
+	if err := peer.pc.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	if peer.pendingOffer {
+		s.negotiate(peer)
+	}
+	return nil
+}
+
+func (s *sfuSession) addICECandidate(clientID string, candidate webrtc.ICECandidateInit) error {
+	s.mu.Lock()
+	peer, ok := s.peers[clientID]
+	s.mu.Unlock()
+
+	if !ok {
+		return errors.New("not connected to sfu")
+	}
+	return peer.pc.AddICECandidate(candidate)
+}
+
+// leave drops a participant's PeerConnection; its published track ends with
+// it and the remaining peers are renegotiated by forward's cleanup.
+func (s *sfuSession) leave(clientID string) {
+	s.mu.Lock()
+	peer, ok := s.peers[clientID]
+	s.mu.Unlock()
+
+	if ok {
+		s.drop(clientID, peer.pc)
+	}
+}
+
+// drop removes pc if it is still the client's current connection, so a
+// late state callback from a replaced connection cannot evict its successor.
+func (s *sfuSession) drop(clientID string, pc *webrtc.PeerConnection) {
+	s.mu.Lock()
+	peer, ok := s.peers[clientID]
+	current := ok && peer.pc == pc
+	if current {
+		delete(s.peers, clientID)
+	}
+	s.mu.Unlock()
+
+	_ = pc.Close()
+	if current {
+		log.Printf("🛰️ %s left SFU for room %s", clientID, s.roomID)
+	}
+}
+
+func (s *sfuSession) close() {
+	s.mu.Lock()
+	s.closed = true
+	peers := s.peers
+	s.peers = make(map[string]*sfuPeer)
+	s.mu.Unlock()
+
+	for _, peer := range peers {
+		_ = peer.pc.Close()
+	}
+	log.Printf("🛰️ Closed SFU session for room %s", s.roomID)
+}
+
+// handleSFUMessage dispatches the sfu-* signaling messages exchanged between
+// a client and the server-side peer.
+func handleSFUMessage(client *Client, msg clientMessage) {
+	var session *sfuSession
+	if room, exists := lockClientRoom(client); exists {
+		session = roomSFU(room, room.ID)
+		room.mu.Unlock()
+	}
+
+	if session == nil {
+		sendError(client, "Room is not using SFU topology")
+		return
+	}
+
+	switch m := msg.(type) {
+	case *sfuAnswerMessage:
+		answer := webrtc.SessionDescription{Type: webrtc.NewSDPType(m.Answer.Type), SDP: m.Answer.SDP}
+		if err := session.answer(client.ID, answer); err != nil {
+			log.Printf("❌ SFU answer from %s rejected: %v", client.ID, err)
+		}
+
+	case *sfuCandidateMessage:
+		candidate := webrtc.ICECandidateInit{
+			SDPMid:           m.Candidate.SDPMid,
+			SDPMLineIndex:    m.Candidate.SDPMLineIndex,
+			UsernameFragment: m.Candidate.UsernameFragment,
+		}
+		if m.Candidate.Candidate != nil {
+			candidate.Candidate = *m.Candidate.Candidate
+		}
+		if err := session.addICECandidate(client.ID, candidate); err != nil {
+			log.Printf("⚠️ SFU ICE candidate from %s rejected: %v", client.ID, err)
+		}
+
+	case *bareMessage:
+		switch m.Type {
+		case "sfu-join":
+			if err := session.join(client); err != nil {
+				log.Printf("❌ SFU join failed for %s: %v", client.ID, err)
+				sendError(client, "Could not join SFU")
+			}
+		case "sfu-leave":
+			session.leave(client.ID)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const maxBreakouts = 20
+
+// breakoutParentID resolves a breakout room to its parent; other rooms are
+// their own parent. Caller must hold the room's lock.
+func breakoutParentID(roomID string) string {
+	if room, ok := rooms.get(roomID); ok && room.ParentID != "" {
+		return room.ParentID
+	}
+	return roomID
+}
+
+// moveClient transfers a connected client to another room in the same
+// family and tells it to rebuild its peer connections there. Caller must
+// hold the family's lock.
+func moveClient(client *Client, roomID string) {
+	if client.RoomID == roomID {
+		return
+	}
+
+	detachFromRoom(client)
+
+	client.send(&outMoveToRoom{
+		messageType:  typed("move-to-room"),
+		RoomID:       roomID,
+		ParentRoomID: breakoutParentID(roomID),
+	})
+
+	attachToRoom(roomID, client)
+	log.Printf("🚪 Moved %s to room %s", client.ID, roomID)
+}
+
+// openBreakout opens a parent's nth breakout under an unguessable ID that
+// this instance holds the lease on, picking another if that one is taken
+// here or elsewhere. It returns nil if it cannot find one. Caller must
+// hold the family's lock.
+func openBreakout(parent *Room, n int) *Room {
+	b := make([]byte, 4)
+	for attempt := 0; attempt < 5; attempt++ {
+		_, _ = crand.Read(b)
+		id := fmt.Sprintf("%s-breakout-%d-%s", parent.ID, n, hex.EncodeToString(b))
+		if home := claimRoom(id); home != instanceID {
+			log.Printf("⚠️ Breakout %s is already open on instance %s", id, home)
+			continue
+		}
+		breakout := newRoom(id, parent.mu)
+		breakout.ParentID = parent.ID
+		if _, opened := rooms.open(breakout); opened {
+			recordEvent(breakout, evRoomOpened, parent.HostID, roomOpenedEvent{HostID: parent.HostID})
+			return breakout
+		}
+	}
+	return nil
+}
+
+func createBreakouts(host *Client, count int, mode string, assignments map[string]int, duration time.Duration) {
+	parent, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer parent.mu.Unlock()
+
+	parentID := parent.ID
+	if parent.HostID != host.ID || parent.ParentID != "" {
+		return
+	}
+	if len(parent.Breakouts) > 0 {
+		sendError(host, "Breakout rooms are already open")
+		return
+	}
+	if count < 1 || count > maxBreakouts {
+		sendError(host, fmt.Sprintf("Breakout count must be between 1 and %d", maxBreakouts))
+		return
+	}
+
+	for i := 1; i <= count; i++ {
+		breakout := openBreakout(parent, i)
+		if breakout == nil {
+			closeBreakouts(parentID)
+			sendError(host, "Breakout rooms could not be opened")
+			return
+		}
+		parent.Breakouts = append(parent.Breakouts, breakout.ID)
+	}
+	log.Printf("🧩 Created %d breakout rooms for %s", count, parentID)
+
+	participants := []string{}
+	for id := range parent.Clients {
+		if id != parent.HostID {
+			participants = append(participants, id)
+		}
+	}
+	sort.Strings(participants)
+
+	targets := map[string]string{}
+	if mode == "random" {
+		rand.Shuffle(len(participants), func(i, j int) {
+			participants[i], participants[j] = participants[j], participants[i]
+		})
+		for i, id := range participants {
+			targets[id] = parent.Breakouts[i%count]
+		}
+	} else {
+		for id, n := range assignments {
+			if _, present := parent.Clients[id]; present && n >= 1 && n <= count {
+				targets[id] = parent.Breakouts[n-1]
+			}
+		}
+	}
+
+	for id, roomID := range targets {
+		moveClient(parent.Clients[id], roomID)
+	}
+
+	if duration > 0 {
+		parent.BreakoutEndsAt = time.Now().Add(duration)
+		parent.breakoutTimer = time.AfterFunc(duration, func() {
+			parent, exists := lockRoom(parentID)
+			if !exists {
+				return
+			}
+			defer parent.mu.Unlock()
+			log.Printf("⏰ Breakout time is up for %s", parentID)
+			closeBreakouts(parentID)
+		})
+	}
+
+	broadcastRoomState(parentID)
+}
+
+func assignBreakout(host *Client, userID, roomID string) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	parentID := breakoutParentID(room.ID)
+	parent, exists := rooms.get(parentID)
+	if !exists || parent.HostID != host.ID {
+		return
+	}
+
+	family := append([]string{parentID}, parent.Breakouts...)
+	if !containsString(family, roomID) {
+		return
+	}
+
+	target, ok := clients.get(userID)
+	if !ok || !containsString(family, target.roomID()) {
+		return
+	}
+
+	moveClient(target, roomID)
+	broadcastRoomState(parentID)
+}
+
+func broadcastToBreakouts(host *Client, text string) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	parentID := breakoutParentID(room.ID)
+	parent, exists := rooms.get(parentID)
+	if !exists || parent.HostID != host.ID || len(parent.Breakouts) == 0 {
+		return
+	}
+
+	msg := &outBreakoutBroadcast{messageType: typed("breakout-broadcast"), From: host.ID, Message: text}
+	broadcastMessage(parentID, msg)
+	for _, id := range parent.Breakouts {
+		broadcastMessage(id, msg)
+	}
+}
+
+// closeBreakouts brings every breakout participant back to the parent room
+// and discards the breakouts. Caller must hold the family's lock.
+func closeBreakouts(parentID string) {
+	parent, exists := rooms.get(parentID)
+	if !exists || len(parent.Breakouts) == 0 {
+		return
+	}
+
+	if parent.breakoutTimer != nil {
+		parent.breakoutTimer.Stop()
+		parent.breakoutTimer = nil
+	}
+
+	for _, id := range parent.Breakouts {
+		breakout, ok := rooms.get(id)
+		if !ok {
+			continue
+		}
+		returning := make([]*Client, 0, len(breakout.Clients))
+		for _, c := range breakout.Clients {
+			returning = append(returning, c)
+		}
+		for _, c := range returning {
+			moveClient(c, parentID)
+		}
+		rooms.remove(breakout)
+	}
+
+	log.Printf("🔙 Closed %d breakout rooms for %s", len(parent.Breakouts), parentID)
+	parent.Breakouts = nil
+	parent.BreakoutEndsAt = time.Time{}
+	broadcastRoomState(parentID)
+}
+
+func breakoutSummaries(parent *Room) []breakoutView {
+	summaries := []breakoutView{}
+	for _, id := range parent.Breakouts {
+		users := []string{}
+		if breakout, ok := rooms.get(id); ok {
+			for userID := range breakout.Clients {
+				users = append(users, userID)
+			}
+		}
+		sort.Strings(users)
+		summaries = append(summaries, breakoutView{RoomID: id, Users: users})
+	}
+	return summaries
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	reactionWindow         = time.Second
+	maxReactionsPerWindow  = 20
+	minReactionInterval    = 500 * time.Millisecond
+	maxEmojiReactionLength = 16
+)
+
+// reactionTally throttles reaction events for a room and keeps the aggregate
+// counters the host sees in room-state. Fist-to-five answers are kept per
+// user so the temperature check reflects each participant's latest choice.
+// Guarded by the room's lock like the rest of Room.
+type reactionTally struct {
+	windowStart  time.Time
+	windowCount  int
+	lastByClient map[string]time.Time
+	counts       map[string]map[string]int
+	fistToFive   map[string]int
+}
+
+func newReactionTally() *reactionTally {
+	t := &reactionTally{}
+	t.reset()
+	return t
+}
+
+func (t *reactionTally) reset() {
+	t.lastByClient = make(map[string]time.Time)
+	t.counts = map[string]map[string]int{
+		"emoji":  {},
+		"thumbs": {},
+	}
+	t.fistToFive = make(map[string]int)
+}
+
+// allow applies both the per-client interval and the per-room window.
+func (t *reactionTally) allow(clientID string, now time.Time) bool {
+	if last, ok := t.lastByClient[clientID]; ok && now.Sub(last) < minReactionInterval {
+		return false
+	}
+
+	if now.Sub(t.windowStart) >= reactionWindow {
+		t.windowStart = now
+		t.windowCount = 0
+	}
+	if t.windowCount >= maxReactionsPerWindow {
+		return false
+	}
+
+	t.windowCount++
+	t.lastByClient[clientID] = now
+	return true
+}
+
+func (t *reactionTally) record(clientID, kind, value string) {
+	if kind == "fist-to-five" {
+		n, _ := strconv.Atoi(value)
+		t.fistToFive[clientID] = n
+		return
+	}
+	t.counts[kind][value]++
+}
+
+func (t *reactionTally) forget(clientID string) {
+	delete(t.lastByClient, clientID)
+	delete(t.fistToFive, clientID)
+}
+
+func (t *reactionTally) summary() reactionsView {
+	distribution := make([]int, 6)
+	sum := 0
+	for _, n := range t.fistToFive {
+		distribution[n]++
+		sum += n
+	}
+
+	temperature := fistToFiveView{Responses: len(t.fistToFive), Distribution: distribution}
+	if len(t.fistToFive) > 0 {
+		average := float64(sum) / float64(len(t.fistToFive))
+		temperature.Average = &average
+	}
+
+	emoji := make(map[string]int, len(t.counts["emoji"]))
+	for k, v := range t.counts["emoji"] {
+		emoji[k] = v
+	}
+	thumbs := make(map[string]int, len(t.counts["thumbs"]))
+	for k, v := range t.counts["thumbs"] {
+		thumbs[k] = v
+	}
+
+	return reactionsView{Emoji: emoji, Thumbs: thumbs, FistToFive: temperature}
+}
+
+func validReaction(kind, value string) bool {
+	switch kind {
+	case "emoji":
+		return value != "" && utf8.RuneCountInString(value) <= maxEmojiReactionLength
+	case "thumbs":
+		return value == "up" || value == "down" || value == "sideways"
+	case "fist-to-five":
+		n, err := strconv.Atoi(value)
+		return err == nil && n >= 0 && n <= 5
+	}
+	return false
+}
+
+func handleReaction(client *Client, kind, value string) {
+	if !validReaction(kind, value) {
+		return
+	}
+
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if !room.Reactions.allow(client.ID, time.Now()) {
+		log.Printf("🐢 Throttled %s reaction from %s in room %s", kind, client.ID, room.ID)
+		return
+	}
+	room.Reactions.record(client.ID, kind, value)
+
+	broadcastMessage(room.ID, &outReaction{messageType: typed("reaction"), UserID: client.ID, Kind: kind, Value: value})
+
+	if host, ok := room.Clients[room.HostID]; ok {
+		sendRoomStateTo(room.ID, host)
+	}
+}
@@ -2,24 +2,63 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	ws "github.com/gofiber/websocket/v2"
-	"github.com/google/uuid"
+
+	"github.com/nbursa/agoranet/models"
 )
 
+// Client is one WebSocket connection. ID is the connection's signaling ID;
+// UserID is the identity it claimed in init. They differ only under the
+// multi-device policy.
 type Client struct {
 	ID     string
+	UserID string
+	// account is the username proven by the JWT the client sent with
+	// init, if any.
+	account string
+
+	// mu guards the fields below. RoomID also changes only under the lock
+	// of the room being entered, so that lock is enough to read it.
+	mu     sync.Mutex
 	Conn   *ws.Conn
 	RoomID string
-	mu     sync.Mutex
+	out    *outbox
+
+	// A dropped client is suspended, not removed, until its grace timer
+	// fires; a new connection presenting resumeToken takes it over.
+	resumeToken string
+	suspended   bool
+	graceTimer  *time.Timer
+	evicted     bool
+
+	// When a client's room runs on another instance, home names that
+	// instance and a proxy client there stands in for it, with origin
+	// naming the instance holding the socket. link tells one binding from
+	// the next.
+	home   string
+	origin string
+	link   string
+	// homeRoom and homeJoin are the room on home and the join that bound
+	// the client to it, replayed if the room moves to another instance.
+	homeRoom string
+	homeJoin []byte
+	// Relays to other instances are queued under mu, in the order the
+	// state above changed, and published under relayMu once mu is free.
+	relayMu sync.Mutex
+	relays  []pendingRelay
+
+	latency atomic.Int64 // last ping round trip, ms
 }
 
 type PastVote struct {
@@ -29,19 +68,37 @@ type PastVote struct {
 	NoCount     int    `json:"noCount"`
 }
 
+// Room is a live room. Its RoomState is derived from the room's event log
+// and changes only through recordEvent. mu guards everything in the room;
+// breakouts share their parent's, so moving people between them is a
+// single critical section.
 type Room struct {
+	RoomState
+	mu           *sync.Mutex
+	ID           string
 	Clients      map[string]*Client
-	HostID       string
-	ActiveVote   string
-	CurrentVotes map[string]string
-	LastMedia    map[string]interface{}
-	PastVotes    []PastVote
+	Reactions    *reactionTally
+	Activity     *participationTracker
+	Floor        *floorControl
+	Minutes      *minutesRecorder
+	AutoTopology bool
+	recent       []models.RoomEvent
+	sfu          *sfuSession
+	migration    *topologyMigration
+	recording    *roomRecorder
+	livestream   *roomLivestream
+
+	// Breakout bookkeeping: a parent lists its breakouts, a breakout
+	// points back at its parent.
+	ParentID       string
+	Breakouts      []string
+	BreakoutEndsAt time.Time
+	breakoutTimer  *time.Timer
 }
 
 var (
-	roomLock sync.Mutex
-	rooms    = make(map[string]*Room)
-	clients  = make(map[string]*Client)
+	rooms   = &roomRegistry{rooms: make(map[string]*Room)}
+	clients = &clientRegistry{clients: make(map[string]*Client)}
 )
 
 func StartSignalingServer(port string) {
@@ -85,114 +142,233 @@ func StartSignalingServer(port string) {
 	}()
 }
 
-func handleMessage(client *Client, msg map[string]interface{}) {
-	switch msg["type"] {
-	case "join":
-		if roomID, ok := msg["roomId"].(string); ok {
-			isCreator := false
-			if v, ok := msg["isCreator"].(bool); ok && v {
-				isCreator = true
-			}
-			client.RoomID = roomID
-			registerClient(roomID, client, isCreator)
+func handleMessage(client *Client, msg clientMessage) {
+	switch m := msg.(type) {
+	case *joinMessage:
+		registerClient(m.RoomID, client, m.IsCreator, false)
+		if m.Topology != "" && m.IsCreator {
+			setTopology(client, m.Topology)
 		}
 
-	case "offer", "answer", "ice-candidate":
-		if targetID, ok := msg["userId"].(string); ok {
-			msg["from"] = client.ID
-			log.Printf("📡 Forwarding %s from %s to %s", msg["type"], msg["from"], targetID)
-			forwardMessage(targetID, msg)
+	case *setTopologyMessage:
+		setTopology(client, m.Topology)
+
+	case *topologyReadyMessage:
+		markTopologyReady(client, m.SwitchID)
+
+	case *sfuAnswerMessage, *sfuCandidateMessage:
+		handleSFUMessage(client, msg)
+
+	case *setStageModeMessage:
+		setStageMode(client, m.Enabled, m.Speakers)
+
+	case *userMessage:
+		switch m.Type {
+		case "promote-speaker", "demote-speaker":
+			setSpeaker(client, m.UserID, m.Type == "promote-speaker")
+		case "unmute-participant":
+			liftMute(client, m.UserID)
 		}
 
-	case "leave":
+	case *agendaItemMessage:
+		addAgendaItem(client, m.Title)
+
+	case *highlightMessage:
+		addHighlight(client, m.Text)
+
+	case *speakingLimitsMessage:
+		setSpeakingLimits(client, m.TurnSeconds, m.TotalSeconds)
+
+	case *goAroundMessage:
+		startGoAround(client, m.Order, m.SecondsEach)
+
+	case *offerMessage:
+		forwardMesh(client, m.UserID, &outOffer{messageType: m.messageType, UserID: m.UserID, From: client.ID, Offer: m.Offer})
+
+	case *answerMessage:
+		forwardMesh(client, m.UserID, &outAnswer{messageType: m.messageType, UserID: m.UserID, From: client.ID, Answer: m.Answer})
+
+	case *iceCandidateMessage:
+		forwardMesh(client, m.UserID, &outICECandidate{messageType: m.messageType, UserID: m.UserID, From: client.ID, Candidate: m.Candidate})
+
+	case *leaveMessage:
 		removeClient(client)
 
-	case "share-media":
-		if url, ok := msg["url"].(string); ok {
-			if mediaType, ok2 := msg["mediaType"].(string); ok2 {
-				roomLock.Lock()
-				if room, exists := rooms[client.RoomID]; exists {
-					room.LastMedia = map[string]interface{}{
-						"type":      "shared-media",
-						"userId":    client.ID,
-						"url":       url,
-						"mediaType": mediaType,
-					}
-					broadcastRoomState(client.RoomID)
-				}
-				roomLock.Unlock()
-			}
+	case *shareMediaMessage:
+		shareMessage(client, m)
+
+	case *itemMessage:
+		switch m.Type {
+		case "pin-media", "unpin-media":
+			pinGalleryItem(client, m.ItemID, m.Type == "pin-media")
+		case "remove-media":
+			removeGalleryItem(client, m.ItemID)
 		}
 
-	case "create-vote":
-		if question, ok := msg["question"].(string); ok {
-			roomLock.Lock()
-			if room, exists := rooms[client.RoomID]; exists && room.HostID == client.ID {
-				room.ActiveVote = question
-				room.CurrentVotes = make(map[string]string)
-				broadcastRoomState(client.RoomID)
+	case *presentPageMessage:
+		presentPage(client, m)
+
+	case *createVoteMessage:
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID {
+				recordEvent(room, evVoteOpened, client.ID, map[string]interface{}{"question": m.Question})
+				broadcastRoomState(room.ID)
 			}
-			roomLock.Unlock()
+			room.mu.Unlock()
 		}
 
-	case "end-vote":
-		roomLock.Lock()
-		if room, exists := rooms[client.RoomID]; exists && room.HostID == client.ID {
+	case *voteMessage:
+		if room, exists := lockClientRoom(client); exists {
 			if room.ActiveVote != "" {
-				vote := PastVote{
-					Question:   room.ActiveVote,
-					TotalVotes: len(room.CurrentVotes),
+				if _, voted := room.CurrentVotes[client.ID]; !voted {
+					room.Activity.voted(client.ID)
 				}
-				for _, v := range room.CurrentVotes {
-					if v == "yes" {
-						vote.YesCount++
-					} else if v == "no" {
-						vote.NoCount++
-					}
+				recordEvent(room, evVoteCast, client.ID, voteCastEvent{UserID: client.ID, Value: m.Value})
+				broadcastRoomState(room.ID)
+			}
+			room.mu.Unlock()
+		}
+
+	case *reactionMessage:
+		handleReaction(client, m.Kind, m.Value)
+
+	case *createBreakoutsMessage:
+		createBreakouts(client, m.Count, m.Mode, m.Assignments, time.Duration(m.DurationSeconds)*time.Second)
+
+	case *assignBreakoutMessage:
+		assignBreakout(client, m.UserID, m.RoomID)
+
+	case *breakoutBroadcastMessage:
+		broadcastToBreakouts(client, m.Message)
+
+	case *roomInfoMessage:
+		updateRoomInfo(client, m)
+
+	case *speakingMessage:
+		if room, exists := lockClientRoom(client); exists {
+			changed := room.Activity.speaking(client.ID, m.IsSpeaking, time.Now())
+			broadcastMessage(room.ID, &outSpeaking{messageType: m.messageType, UserID: client.ID, IsSpeaking: m.IsSpeaking})
+			// Refresh the host's live shares whenever a turn ends.
+			if changed && !m.IsSpeaking {
+				if host, ok := room.Clients[room.HostID]; ok {
+					sendRoomStateTo(room.ID, host)
 				}
-				room.PastVotes = append(room.PastVotes, vote)
 			}
-			room.ActiveVote = ""
-			room.CurrentVotes = make(map[string]string)
-			broadcastRoomState(client.RoomID)
-		}
-		roomLock.Unlock()
-
-	case "vote":
-		if userID, ok1 := msg["userId"].(string); ok1 {
-			if value, ok2 := msg["value"].(string); ok2 {
-				roomLock.Lock()
-				if room, exists := rooms[client.RoomID]; exists && room.ActiveVote != "" {
-					room.CurrentVotes[userID] = value
-					broadcastRoomState(client.RoomID)
+			room.mu.Unlock()
+		}
+
+	case *bareMessage:
+		handleBareMessage(client, m)
+	}
+}
+
+// handleBareMessage handles the messages that carry nothing but their type.
+func handleBareMessage(client *Client, m *bareMessage) {
+	switch m.Type {
+	case "sfu-join", "sfu-leave":
+		handleSFUMessage(client, m)
+
+	case "raise-hand":
+		raiseHand(client, true)
+
+	case "lower-hand":
+		raiseHand(client, false)
+
+	case "next-speaker", "yield-floor":
+		nextSpeaker(client)
+
+	case "stop-go-around":
+		stopGoAround(client)
+
+	case "start-recording":
+		startRecording(client)
+
+	case "start-livestream":
+		startLivestream(client)
+
+	case "stop-livestream":
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID && room.livestream != nil {
+				stopLivestream(room)
+				evaluateTopology(room.ID)
+				broadcastRoomState(room.ID)
+			}
+			room.mu.Unlock()
+		}
+
+	case "stop-recording":
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID && room.recording != nil {
+				stopRecording(room)
+				evaluateTopology(room.ID)
+				broadcastRoomState(room.ID)
+			}
+			room.mu.Unlock()
+		}
+
+	case "stop-presenting":
+		stopPresenting(client)
+
+	case "clear-media":
+		clearSharedMedia(client)
+
+	case "end-vote":
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID {
+				if room.ActiveVote != "" {
+					recordEvent(room, evVoteClosed, client.ID, nil)
+					room.Minutes.voteClosed(room.PastVotes[len(room.PastVotes)-1], time.Now())
 				}
-				roomLock.Unlock()
+				broadcastRoomState(room.ID)
 			}
+			room.mu.Unlock()
 		}
-		
-	case "speaking":
-		if isSpeaking, ok := msg["isSpeaking"].(bool); ok {
-			broadcastMessage(client.RoomID, map[string]interface{}{
-				"type":       "speaking",
-				"userId":     client.ID,
-				"isSpeaking": isSpeaking,
-			})
+
+	case "clear-reactions":
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID {
+				room.Reactions.reset()
+				sendRoomStateTo(room.ID, client)
+			}
+			room.mu.Unlock()
+		}
+
+	case "close-breakouts":
+		if room, exists := lockClientRoom(client); exists {
+			if room.HostID == client.ID {
+				closeBreakouts(breakoutParentID(room.ID))
+			}
+			room.mu.Unlock()
 		}
 	}
 }
 
+// forwardMesh relays a mesh offer, answer or ICE candidate to targetID,
+// unless the room is in stage mode.
+func forwardMesh(client *Client, targetID string, msg serverMessage) {
+	kind := msg.kind()
+	stage := false
+	if room, exists := lockClientRoom(client); exists {
+		stage = room.StageMode
+		room.mu.Unlock()
+	}
+	if stage {
+		log.Printf("🎙️ Dropping mesh %s from %s: room is in stage mode", kind, client.ID)
+		return
+	}
+
+	log.Printf("📡 Forwarding %s from %s to %s", kind, client.ID, targetID)
+	forwardMessage(client, targetID, msg)
+}
+
 func HandleWebSocket(c *ws.Conn) {
-	clientID := ""
+	var client *Client
+	out := newOutbox(c)
 	defer func() {
-		if clientID != "" {
-			client, exists := clients[clientID]
-			if !exists || client == nil {
-				log.Printf("⚠️ Skipping cleanup: client %s already nil or removed", clientID)
-				return
-			}
-			removeClient(client)
-			log.Println("❌ Disconnected:", clientID)
+		if client != nil {
+			dropConnection(client, c)
 		}
+		out.detach()
 	}()
 
 	_, msgBytes, err := c.ReadMessage()
@@ -201,158 +377,327 @@ func HandleWebSocket(c *ws.Conn) {
 		return
 	}
 
-	var initMsg map[string]interface{}
-	if err := json.Unmarshal(msgBytes, &initMsg); err != nil {
-		log.Println("❌ Invalid JSON in init message:", err)
-		return
+	// The version is unknown until init is read, so it is decoded leniently
+	// first and again under the version it asks for.
+	initMsg, err := decodeMessage(msgBytes, minProtocolVersion)
+	if err == nil && initMsg.kind() != "init" {
+		err = errors.New("first message must be init")
 	}
-
-	if initMsg["type"] != "init" {
-		log.Println("❌ First message must be 'init'")
+	var version int
+	if err == nil {
+		version = negotiateProtocol(initMsg.(*initMessage).Protocol)
+		initMsg, err = decodeMessage(msgBytes, version)
+	}
+	if err != nil {
+		log.Println("❌ Invalid init message:", err)
+		// Nothing is queued yet, so writing directly cannot interleave.
+		_ = c.WriteJSON(invalidMessage(err))
 		return
 	}
+	init := initMsg.(*initMessage)
 
-	providedID, _ := initMsg["userId"].(string)
-	clientID = providedID
-	if clientID == "" {
-		clientID = uuid.New().String()
+	resumeID := init.ConnectionID
+	if resumeID == "" {
+		resumeID = init.UserID
+	}
+	client = resumeSession(out, resumeID, init.ResumeToken, init.LastSeq, version)
+	if client == nil {
+		if client = admitClient(out, init.UserID, verifiedAccount(init.AuthToken), version); client == nil {
+			return
+		}
 	}
 
-	client := &Client{ID: clientID, Conn: c}
-	clients[clientID] = client
-	log.Println("🔌 Connected:", clientID)
-
-	_ = c.WriteJSON(map[string]interface{}{"type": "init", "userId": clientID})
+	stop := make(chan struct{})
+	pinger := startHeartbeat(c, func(rtt time.Duration) { client.latency.Store(max(rtt.Milliseconds(), 1)) }, stop)
+	defer func() {
+		close(stop)
+		<-pinger
+	}()
 
 	for {
 		_, rawMessage, err := c.ReadMessage()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && !closedByServer(client, c) {
+				log.Printf("💔 No heartbeat from %s", client.ID)
+			}
 			break
 		}
+		extendDeadline(c)
+		receiveMessage(client, rawMessage, version)
+	}
+}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(rawMessage, &msg); err != nil {
-			continue
+// receiveMessage decodes one message from client under the protocol
+// version it speaks and handles it. Version 2 clients are told what was wrong with
+// an invalid one; older clients never expected a reply.
+func receiveMessage(client *Client, raw []byte, version int) {
+	msg, err := decodeMessage(raw, version)
+	if err != nil {
+		if version >= 2 {
+			client.send(invalidMessage(err))
+		} else {
+			log.Printf("⚠️ Ignoring message from %s: %v", client.ID, err)
 		}
+		return
+	}
 
-		handleMessage(client, msg)
+	if routeMessage(client, msg, raw) {
+		return
 	}
+	handleMessage(client, msg)
 }
 
-func registerClient(roomID string, client *Client, isCreator bool) {
-	roomLock.Lock()
-	defer roomLock.Unlock()
-
-	room, exists := rooms[roomID]
+// registerClient joins the client to a room, opening it if the client may.
+// reopen marks a join replayed because the room moved here from another
+// instance, which may reopen it while its host is still on the way.
+func registerClient(roomID string, client *Client, isCreator, reopen bool) {
+	// Joining another room leaves the current one.
+	if current, inRoom := lockClientRoom(client); inRoom {
+		if current.ID != roomID {
+			detachFromRoom(client)
+		}
+		current.mu.Unlock()
+	}
 
+	room, exists := lockRoom(roomID)
 	if !exists {
-		if isCreator {
-			room = &Room{
-				Clients:      make(map[string]*Client),
-				HostID:       client.ID,
-				ActiveVote:   "",
-				CurrentVotes: make(map[string]string),
-				LastMedia:    nil,
-				PastVotes:    []PastVote{},
+		// A running scheduled session belongs to whoever scheduled it;
+		// its host slot stays empty until they arrive.
+		owner, scheduled := scheduledRoomOwner(roomID, time.Now())
+		hostID := ""
+		switch {
+		case scheduled:
+			if client.account == owner {
+				hostID = client.ID
 			}
-			rooms[roomID] = room
-			log.Printf("👑 Created room %s (host: %s)", roomID, client.ID)
-		} else {
+		case isCreator:
+			hostID = client.ID
+		case reopen:
+		default:
 			log.Printf("⛔ Rejected guest trying to join non-existent room %s", roomID)
-			_ = client.Conn.WriteJSON(map[string]interface{}{
-				"type":  "error",
-				"error": "Room does not exist",
-			})
+			sendError(client, "Room does not exist")
 			return
 		}
+		// Room IDs get reused, so a new session is listed only once its
+		// host lists it.
+		info := loadRoomInfo(roomID)
+		info.Listed = false
+		var opened bool
+		room, opened = openRoom(roomID, hostID, owner, info, recentGallery(roomID))
+		switch {
+		case !opened:
+			log.Printf("🔁 Joined room %s: %s", roomID, client.ID)
+		case hostID != "":
+			log.Printf("👑 Created room %s (host: %s)", roomID, client.ID)
+		case scheduled:
+			log.Printf("📅 Opened scheduled room %s for %s", roomID, client.ID)
+		default:
+			log.Printf("🛰️ Reopened room %s for %s after it moved here", roomID, client.ID)
+		}
 	} else {
 		log.Printf("🔁 Joined room %s: %s", roomID, client.ID)
 	}
+	defer room.mu.Unlock()
 
-	if room.HostID == "" && isCreator {
-		room.HostID = client.ID
+	canHost := isCreator
+	if room.Owner != "" {
+		canHost = client.account == room.Owner
+	}
+	if room.HostID == "" && canHost {
+		recordEvent(room, evHostAssigned, client.ID, nil)
 		log.Printf("⚠️ Host reassigned to %s (allowed as creator)", client.ID)
 	} else if room.HostID != client.ID {
 		log.Printf("🛡️ Preserving host %s, %s is guest", room.HostID, client.ID)
 	}
 
+	attachToRoom(roomID, client)
+}
+
+// newRoom builds an empty room guarded by mu. Its state starts with the
+// room-opened event its creator records.
+func newRoom(roomID string, mu *sync.Mutex) *Room {
+	return &Room{
+		mu:        mu,
+		ID:        roomID,
+		Clients:   make(map[string]*Client),
+		Reactions: newReactionTally(),
+		Activity:  newParticipationTracker(),
+		Floor:     newFloorControl(),
+		Minutes:   newMinutesRecorder(),
+	}
+}
+
+// openRoom opens a top-level room and returns it locked. If another join
+// opened it first, that room is returned, locked, with opened false. A
+// room with an owner can only be hosted by that account.
+func openRoom(roomID, hostID, owner string, info models.Room, gallery []models.GalleryItem) (*Room, bool) {
+	room := newRoom(roomID, new(sync.Mutex))
+	room.mu.Lock()
+	if _, opened := rooms.open(room); !opened {
+		room.mu.Unlock()
+		if existing, ok := lockRoom(roomID); ok {
+			return existing, false
+		}
+		return openRoom(roomID, hostID, owner, info, gallery)
+	}
+
+	recordEvent(room, evRoomOpened, hostID, roomOpenedEvent{
+		HostID:    hostID,
+		Owner:     owner,
+		Info:      roomInfoOf(info),
+		CreatedAt: info.CreatedAt,
+		UpdatedAt: info.UpdatedAt,
+		Gallery:   gallery,
+	})
+	return room, true
+}
+
+// attachToRoom adds the client to an existing room and announces the new
+// membership. Caller must hold the room's lock.
+func attachToRoom(roomID string, client *Client) {
+	room, exists := rooms.get(roomID)
+	if !exists || !client.enterRoom(roomID) {
+		return
+	}
+
 	room.Clients[client.ID] = client
+	recordEvent(room, evJoined, client.ID, nil)
+	room.Activity.joined(client.ID, time.Now())
+	room.Minutes.joined(roomID, client.ID, time.Now())
+	ensureFloorTicker(room)
+	if room.StageMode {
+		sendStageRole(room, client)
+	}
+	evaluateTopology(roomID)
 	broadcastRoomState(roomID)
+	sendGallery(room, client)
 }
 
-func removeClient(client *Client) {
-	if client == nil {
-		log.Println("⚠️ Tried to remove nil client")
+// detachFromRoom removes the client from its current room without closing
+// the connection, telling the remaining peers to drop it. Caller must hold
+// the room's lock.
+func detachFromRoom(client *Client) {
+	room, exists := rooms.get(client.RoomID)
+	if !exists || room.Clients[client.ID] != client {
 		return
 	}
 
-	roomLock.Lock()
-	defer roomLock.Unlock()
+	client.leaveRoom()
+	delete(room.Clients, client.ID)
+	room.Reactions.forget(client.ID)
+	room.Activity.speaking(client.ID, false, time.Now())
+	room.Minutes.left(client.ID, time.Now())
+	recordEvent(room, evLeft, client.ID, nil)
+	if room.sfu != nil {
+		go room.sfu.leave(client.ID)
+	}
 
-	log.Printf("Closing connection for client %s", client.ID)
+	for _, peer := range room.Clients {
+		peer.send(&userMessage{messageType: typed("leave"), UserID: client.ID})
+	}
 
-	err := client.Conn.Close()
-	if err != nil {
-		log.Printf("Error closing WebSocket connection for client %s: %v", client.ID, err)
+	if len(room.Clients) == 0 {
+		log.Printf("🕒 Room %s is now empty (host %s preserved)", room.ID, room.HostID)
+		if room.migration != nil {
+			abortMigration(room, room.ID)
+		}
+		stopRecording(room)
+		stopLivestream(room)
+		room.Activity.finish(room.ID, time.Now())
+		room.Minutes.finish(room.Info.Title, time.Now())
+		room.Floor.reset()
+		ensureFloorTicker(room)
+		if room.sfu != nil {
+			go room.sfu.close()
+			room.sfu = nil
+		}
 	} else {
-		log.Printf("Successfully closed WebSocket connection for client %s", client.ID)
+		if room.migration != nil {
+			checkMigrationComplete(room.ID)
+		}
+		evaluateTopology(room.ID)
+		broadcastRoomState(room.ID)
 	}
+}
 
-	delete(clients, client.ID)
-
-	if room, exists := rooms[client.RoomID]; exists {
-		delete(room.Clients, client.ID)
+func removeClient(client *Client) {
+	if client == nil {
+		log.Println("⚠️ Tried to remove nil client")
+		return
+	}
 
-		for _, peer := range room.Clients {
-			peer.mu.Lock()
-			_ = peer.Conn.WriteJSON(map[string]interface{}{
-				"type":   "leave",
-				"userId": client.ID,
-			})
-			peer.mu.Unlock()
-		}
+	// Marked first, so a join racing with us cannot put it in a room we
+	// have not locked.
+	client.mu.Lock()
+	client.evicted = true
+	client.mu.Unlock()
 
-		if len(room.Clients) == 0 {
-			log.Printf("🕒 Room %s is now empty (host %s preserved)", client.RoomID, room.HostID)
-		} else {
-			broadcastRoomState(client.RoomID)
-		}
+	if room, inRoom := lockClientRoom(client); inRoom {
+		defer room.mu.Unlock()
 	}
+	evictClient(client)
 }
 
-func forwardMessage(targetID string, msg map[string]interface{}) {
-	roomLock.Lock()
-	defer roomLock.Unlock()
+// evictClient closes the client's connection and takes it out of its room
+// for good. Caller must hold the lock of the client's room, if any.
+func evictClient(client *Client) {
+	client.mu.Lock()
+	client.evicted = true
+	if client.graceTimer != nil {
+		client.graceTimer.Stop()
+		client.graceTimer = nil
+	}
+	client.suspended = false
+	out := client.out
+	client.mu.Unlock()
+
+	// The socket closes once messages already queued, such as
+	// session-replaced, are written.
+	log.Printf("Closing connection for client %s", client.ID)
+	out.shutdown()
+	unbindClient(client)
+
+	clients.remove(client)
+	detachFromRoom(client)
+}
 
+// forwardMessage relays a mesh message to one connection in the sender's
+// room.
+func forwardMessage(from *Client, targetID string, msg serverMessage) {
 	// 🔒 Ignore self-targeting
-	if fromID, ok := msg["from"].(string); ok && fromID == targetID {
-		log.Printf("⚠️ Skipping self-forward of %s to %s", msg["type"], targetID)
+	if from.ID == targetID {
+		log.Printf("⚠️ Skipping self-forward of %s to %s", msg.kind(), targetID)
 		return
 	}
 
-	if client, ok := clients[targetID]; ok {
-		err := client.Conn.WriteJSON(msg)
-		if err != nil {
-			log.Printf("❌ Failed to forward %s to %s: %v", msg["type"], targetID, err)
-		}
+	room, exists := lockClientRoom(from)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if client, ok := room.Clients[targetID]; ok && !client.isSuspended() {
+		client.send(msg)
 	}
 }
 
-func broadcastMessage(roomID string, msg map[string]interface{}) {
-	if room, ok := rooms[roomID]; ok {
+func sendError(client *Client, message string) {
+	client.send(&outError{messageType: typed("error"), Error: message})
+}
+
+func broadcastMessage(roomID string, msg serverMessage) {
+	if room, ok := rooms.get(roomID); ok {
 		for _, client := range room.Clients {
-			client.mu.Lock()
-			err := client.Conn.WriteJSON(msg)
-			client.mu.Unlock()
-			if err != nil {
-				log.Printf("❌ Failed to send message to %s: %v", client.ID, err)
+			if client.isSuspended() {
+				continue
 			}
+			client.send(msg)
 		}
 	}
 }
 
 func broadcastRoomState(roomID string) {
-	room, ok := rooms[roomID]
+	room, ok := rooms.get(roomID)
 	if !ok {
 		return
 	}
@@ -363,39 +708,131 @@ func broadcastRoomState(roomID string) {
 	}
 
 	for _, client := range room.Clients {
-		state := map[string]interface{}{
-			"type":         "room-state",
-			"users":        users,
-			"hostId":       room.HostID,
-			"activeVote":   room.ActiveVote,
-			"currentVotes": room.CurrentVotes,
+		if client.isSuspended() {
+			continue
 		}
+		state := roomStateFor(room, client, users)
 
-		if room.LastMedia != nil {
-			state["sharedMedia"] = room.LastMedia
-		}
+		client.send(state)
+	}
+}
+
+// sendRoomStateTo pushes a fresh room-state to a single client, used when
+// only one participant (usually the host) needs to see an update.
+func sendRoomStateTo(roomID string, client *Client) {
+	room, ok := rooms.get(roomID)
+	if !ok {
+		return
+	}
 
-		if client.ID == room.HostID {
-			state["voteHistory"] = room.PastVotes
+	users := []string{}
+	for id := range room.Clients {
+		users = append(users, id)
+	}
+
+	client.send(roomStateFor(room, client, users))
+}
+
+func roomStateFor(room *Room, client *Client, users []string) *outRoomState {
+	state := &outRoomState{
+		messageType:  typed("room-state"),
+		Seq:          lastEventSeq(room.ID),
+		Users:        users,
+		Identities:   identitiesState(room),
+		Latency:      latencyState(room),
+		HostID:       room.HostID,
+		ActiveVote:   room.ActiveVote,
+		CurrentVotes: room.CurrentVotes,
+		RoomInfo:     roomInfoState(room.Info),
+		Topology:     room.Topology,
+		TopologyInfo: topologyState(room),
+		Recording:    recordingState(room),
+		Livestream:   livestreamState(room),
+		Stage:        stageState(room),
+		Floor:        floorState(room),
+		Presentation: presentationState(room),
+	}
+
+	// sharedMedia is the latest item, kept for clients without a gallery
+	// view. The gallery itself is sent on its own; see sendGallery.
+	if n := len(room.Gallery); n > 0 && !room.MediaCleared {
+		item := galleryItemState(room.Gallery[n-1])
+		state.SharedMedia = &item
+	}
+
+	if room.ParentID != "" {
+		state.ParentRoomID = room.ParentID
+		if parent, ok := rooms.get(room.ParentID); ok && !parent.BreakoutEndsAt.IsZero() {
+			state.BreakoutEndsAt = parent.BreakoutEndsAt.UnixMilli()
 		}
+	}
 
-		client.mu.Lock()
-		_ = client.Conn.WriteJSON(state)
-		client.mu.Unlock()
+	if client.ID == room.HostID {
+		reactions := room.Reactions.summary()
+		participation := participationState(room)
+		state.HostToken = RoomAccessToken(room.ID, room.Session, room.OpenedAt)
+		state.VoteHistory = room.PastVotes
+		state.Reactions = &reactions
+		state.Participation = &participation
+		if len(room.Breakouts) > 0 {
+			state.Breakouts = breakoutSummaries(room)
+			if !room.BreakoutEndsAt.IsZero() {
+				state.BreakoutEndsAt = room.BreakoutEndsAt.UnixMilli()
+			}
+		}
 	}
+
+	return state
 }
 
 func HandleDashboardSocket(c *ws.Conn) {
+	// The dashboard only listens, but reading is what notices pongs and a
+	// dead peer.
+	stop := make(chan struct{})
+	gone := make(chan struct{})
+	pinger := startHeartbeat(c, nil, stop)
+	defer func() {
+		close(stop)
+		closeConn(c)
+		<-gone
+		<-pinger
+	}()
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+			extendDeadline(c)
+		}
+	}()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(2 * time.Second)
-
-		roomLock.Lock()
-		var summaries []map[string]interface{}
-		for roomID, room := range rooms {
-			summary := map[string]interface{}{
-				"roomId":           roomID,
-				"hostId":           room.HostID,
-				"participantCount": len(room.Clients),
+		select {
+		case <-gone:
+			log.Println("📊 Dashboard disconnected")
+			return
+		case <-ticker.C:
+		}
+
+		summaries := []dashboardRoomView{}
+		for _, room := range rooms.all() {
+			room.mu.Lock()
+			summary := dashboardRoomView{
+				RoomID:           room.ID,
+				HostID:           room.HostID,
+				ParticipantCount: len(room.Clients),
+				Topology:         room.Topology,
+				MaxQueueDepth:    roomQueueDepth(room),
+				Instance:         instanceID,
+				ParentRoomID:     room.ParentID,
+			}
+			if room.StageMode {
+				stage := stageState(room)
+				summary.SpeakerCount = stage.SpeakerCount
+				summary.ListenerCount = stage.ListenerCount
 			}
 			if room.ActiveVote != "" {
 				yes, no := 0, 0
@@ -406,19 +843,18 @@ func HandleDashboardSocket(c *ws.Conn) {
 						no++
 					}
 				}
-				summary["activeVote"] = map[string]interface{}{
-					"question": room.ActiveVote,
-					"yes":      yes,
-					"no":       no,
-				}
+				summary.ActiveVote = &dashboardVoteView{Question: room.ActiveVote, Yes: yes, No: no}
 			}
+			room.mu.Unlock()
 			summaries = append(summaries, summary)
 		}
-		roomLock.Unlock()
+		outbound := outboundState()
 
-		err := c.WriteJSON(map[string]interface{}{
-			"type":  "dashboard-summary",
-			"rooms": summaries,
+		_ = c.SetWriteDeadline(time.Now().Add(heartbeatWriteWait))
+		err := c.WriteJSON(&outDashboardSummary{
+			messageType: typed("dashboard-summary"),
+			Rooms:       summaries,
+			Outbound:    outbound,
 		})
 		if err != nil {
 			log.Println("❌ Write error:", err)
@@ -0,0 +1,26 @@
+package services
+
+import "testing"
+
+func TestVoteCountsUnderSender(t *testing.T) {
+	host := connectPeer(t, "vote-host")
+	guest := connectPeer(t, "vote-guest")
+	host.join("vote-room", true)
+	guest.join("vote-room", false)
+	host.send(map[string]interface{}{"type": "create-vote", "question": "Adjourn?"})
+
+	// Naming someone else does not vote for them.
+	guest.send(map[string]interface{}{"type": "vote", "userId": host.client.ID, "value": "no"})
+	guest.send(map[string]interface{}{"type": "vote", "value": "yes"})
+
+	room, _ := rooms.get("vote-room")
+	room.mu.Lock()
+	votes := map[string]string{}
+	for id, v := range room.CurrentVotes {
+		votes[id] = v
+	}
+	room.mu.Unlock()
+	if len(votes) != 1 || votes[guest.client.ID] != "yes" {
+		t.Fatalf("votes are %v, want only %s: yes", votes, guest.client.ID)
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"log"
+	"sort"
+)
+
+// Stage mode is for hearings with a few speakers and a large audience.
+// The room is pinned to the SFU and only speakers' audio is forwarded;
+// listeners raise a hand and the host promotes them.
+
+func copyFlags(flags map[string]bool) map[string]bool {
+	out := make(map[string]bool, len(flags))
+	for k, v := range flags {
+		if v {
+			out[k] = true
+		}
+	}
+	return out
+}
+
+// publishers works out whose audio the SFU may forward, from stage mode,
+// the go-around floor and server mutes. Caller must hold the room's lock.
+func publishers(room *Room) (allowed, blocked map[string]bool) {
+	if room.StageMode {
+		allowed = copyFlags(room.Speakers)
+	}
+	if holder := room.Floor.holder(); holder != "" {
+		allowed = map[string]bool{holder: true, room.HostID: true}
+	}
+	return allowed, room.Floor.mutedSet()
+}
+
+// syncPublishers pushes the room's publishing rules into its SFU session.
+// Caller must hold the room's lock.
+func syncPublishers(room *Room) {
+	if room.sfu != nil {
+		allowed, blocked := publishers(room)
+		room.sfu.setPublishers(allowed, blocked)
+	}
+}
+
+func setStageMode(host *Client, enabled bool, speakers []string) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID {
+		return
+	}
+
+	ev := stageModeEvent{Enabled: enabled, Speakers: append([]string{}, speakers...)}
+	recordEvent(room, evStageMode, host.ID, ev)
+
+	if enabled {
+		// Listeners must receive audio from the server, never by mesh.
+		room.AutoTopology = false
+		if room.Topology != topologySFU {
+			if len(room.Clients) <= 1 && room.migration == nil {
+				applyTopology(room, room.ID, topologySFU)
+			} else {
+				beginMigration(room.ID, topologySFU)
+			}
+		}
+	}
+
+	log.Printf("🎙️ Stage mode %v in room %s", enabled, room.ID)
+	syncPublishers(room)
+	notifyStageRoles(room)
+	broadcastRoomState(room.ID)
+}
+
+func raiseHand(client *Client, raised bool) {
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	recordEvent(room, evHand, client.ID, map[string]interface{}{"raised": raised})
+	broadcastRoomState(room.ID)
+}
+
+// setSpeaker promotes a listener to the stage or sends a speaker back to
+// the audience.
+func setSpeaker(host *Client, userID string, speaker bool) {
+	room, exists := lockClientRoom(host)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != host.ID || !room.StageMode {
+		return
+	}
+	if _, present := room.Clients[userID]; !present || userID == room.HostID {
+		return
+	}
+
+	recordEvent(room, evSpeaker, host.ID, speakerEvent{UserID: userID, Speaker: speaker})
+
+	log.Printf("🎙️ %s is now a %s in room %s", userID, stageRole(room, userID), room.ID)
+	syncPublishers(room)
+	sendStageRole(room, room.Clients[userID])
+	broadcastRoomState(room.ID)
+}
+
+func stageRole(room *Room, clientID string) string {
+	if !room.StageMode || room.Speakers[clientID] {
+		return "speaker"
+	}
+	return "listener"
+}
+
+// sendStageRole tells a client whether to attach its microphone. Caller
+// must hold the room's lock.
+func sendStageRole(room *Room, client *Client) {
+	client.send(&outStageRole{messageType: typed("stage-role"), Role: stageRole(room, client.ID)})
+}
+
+func notifyStageRoles(room *Room) {
+	for _, c := range room.Clients {
+		sendStageRole(room, c)
+	}
+}
+
+func stageState(room *Room) stageView {
+	speakers := []string{}
+	listeners := 0
+	for id := range room.Clients {
+		if room.Speakers[id] {
+			speakers = append(speakers, id)
+		} else {
+			listeners++
+		}
+	}
+	sort.Strings(speakers)
+
+	hands := room.RaisedHands
+	if hands == nil {
+		hands = []string{}
+	}
+
+	state := stageView{Enabled: room.StageMode, RaisedHands: hands}
+	if room.StageMode {
+		speakerCount := len(speakers)
+		state.Speakers = speakers
+		state.SpeakerCount = &speakerCount
+		state.ListenerCount = &listeners
+	}
+	return state
+}
+
+func removeString(list []string, value string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != value {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbursa/agoranet/config"
+)
+
+// testPeer is a connection without a socket: its outbox records what the
+// server sends instead of writing it anywhere.
+type testPeer struct {
+	t      *testing.T
+	client *Client
+
+	mu       sync.Mutex
+	received []map[string]interface{}
+	hungUp   bool
+}
+
+func newTestOutbox(p *testPeer) *outbox {
+	o := &outbox{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	o.write = func(entry outboxEntry) error {
+		var msg map[string]interface{}
+		if err := json.Unmarshal(entry.data, &msg); err != nil {
+			p.t.Errorf("server sent invalid JSON: %v", err)
+		}
+		checkServerMessage(p.t, entry)
+		p.mu.Lock()
+		p.received = append(p.received, msg)
+		p.mu.Unlock()
+		return nil
+	}
+	// Called with the outbox's lock held, so it only records.
+	o.hangUp = func() {
+		p.mu.Lock()
+		p.hungUp = true
+		p.mu.Unlock()
+	}
+	go o.run()
+	return o
+}
+
+// checkServerMessage fails the test unless a message the server sent is
+// registered under its type and has only the fields its struct declares,
+// which is what the schema and the client's types are generated from.
+func checkServerMessage(t *testing.T, entry outboxEntry) {
+	newMessage, ok := serverMessages[entry.kind]
+	if !ok {
+		t.Errorf("server sent unregistered message type %q", entry.kind)
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(entry.data))
+	dec.DisallowUnknownFields()
+	msg := newMessage()
+	if err := dec.Decode(msg); err != nil {
+		t.Errorf("server sent a %s that does not match %T: %v", entry.kind, msg, err)
+	}
+}
+
+// connectPeer admits a connection claiming userID, as HandleWebSocket does
+// after init.
+func connectPeer(t *testing.T, userID string) *testPeer {
+	t.Helper()
+	p := &testPeer{t: t}
+	p.client = admitClient(newTestOutbox(p), userID, "", ProtocolVersion)
+	if p.client == nil {
+		t.Fatalf("connection for %s was refused", userID)
+	}
+	t.Cleanup(func() { removeClient(p.client) })
+	return p
+}
+
+// send handles one message from the peer, as HandleWebSocket does.
+func (p *testPeer) send(msg map[string]interface{}) {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		p.t.Errorf("cannot encode %v: %v", msg, err)
+		return
+	}
+	decoded, err := decodeMessage(raw, ProtocolVersion)
+	if err != nil {
+		p.t.Errorf("%s sent an invalid message: %v", p.client.ID, err)
+		return
+	}
+	if !routeMessage(p.client, decoded, raw) {
+		handleMessage(p.client, decoded)
+	}
+}
+
+// reconnect gives a peer that left a new connection under the same ID.
+func (p *testPeer) reconnect() {
+	if client := admitClient(newTestOutbox(p), p.client.UserID, "", ProtocolVersion); client != nil {
+		p.client = client
+	}
+}
+
+func (p *testPeer) join(roomID string, isCreator bool) {
+	p.send(map[string]interface{}{"type": "join", "roomId": roomID, "isCreator": isCreator})
+}
+
+// checkRoomInvariants verifies, room by room, that membership agrees with
+// what each member believes and with the room's event-sourced state.
+func checkRoomInvariants(t *testing.T) {
+	t.Helper()
+	seen := map[string]string{}
+	for _, room := range rooms.all() {
+		room.mu.Lock()
+		if len(room.Participants) != len(room.Clients) {
+			t.Errorf("room %s lists %d participants but has %d clients", room.ID, len(room.Participants), len(room.Clients))
+		}
+		for _, id := range room.Participants {
+			if _, ok := room.Clients[id]; !ok {
+				t.Errorf("room %s lists participant %s who is not in it", room.ID, id)
+			}
+		}
+		for id, client := range room.Clients {
+			if other, dup := seen[id]; dup {
+				t.Errorf("%s is in both %s and %s", id, other, room.ID)
+			}
+			seen[id] = room.ID
+			if client.RoomID != room.ID {
+				t.Errorf("%s is in room %s but thinks it is in %q", id, room.ID, client.RoomID)
+			}
+			if registered, ok := clients.get(id); !ok || registered != client {
+				t.Errorf("%s is in room %s but not registered", id, room.ID)
+			}
+		}
+		for _, id := range room.Breakouts {
+			if breakout, ok := rooms.get(id); !ok || breakout.ParentID != room.ID {
+				t.Errorf("room %s lists breakout %s that is not open", room.ID, id)
+			}
+		}
+		room.mu.Unlock()
+	}
+
+	for _, client := range clients.all() {
+		if roomID := client.roomID(); roomID != "" && seen[client.ID] != roomID {
+			t.Errorf("%s thinks it is in %s but that room does not have it", client.ID, roomID)
+		}
+	}
+}
+
+// saw reports whether the server sent the peer a message of type kind
+// that match accepts, if match is given.
+func (p *testPeer) saw(kind string, match func(msg map[string]interface{}) bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, msg := range p.received {
+		if msg["type"] == kind && (match == nil || match(msg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the last message of type kind the server sent the peer.
+func (p *testPeer) latest(kind string) map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.received) - 1; i >= 0; i-- {
+		if p.received[i]["type"] == kind {
+			return p.received[i]
+		}
+	}
+	return nil
+}
+
+// waitUntil polls cond until it holds, failing the test after two seconds.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestMain runs the tests against a throwaway SQLite database. It is set
+// once, so background writers never see config.DB change under them.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "agoranet-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DB_TYPE", "sqlite")
+	os.Setenv("DB_PATH", filepath.Join(dir, "agoranet.db"))
+	if err := config.InitDatabase(); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const topologyAuto = "auto"
+
+// Auto mode moves a room to the SFU once it reaches SFU_SWITCH_UP
+// participants and back to mesh at SFU_SWITCH_DOWN or fewer; the gap keeps
+// a room hovering around one size from flapping.
+func sfuSwitchUp() int   { return envInt("SFU_SWITCH_UP", 5) }
+func sfuSwitchDown() int { return envInt("SFU_SWITCH_DOWN", 3) }
+
+func topologySwitchTimeout() time.Duration {
+	return envSeconds("TOPOLOGY_SWITCH_TIMEOUT", 10*time.Second)
+}
+
+// topologyMigration is a make-before-break switch: clients bring up the new
+// media path next to the old one, report `topology-ready`, and only tear the
+// old path down on `topology-commit`.
+type topologyMigration struct {
+	id     string
+	target string
+	ready  map[string]bool
+	timer  *time.Timer
+}
+
+// setTopology applies a host's topology choice: "mesh" or "sfu" pin the
+// room, "auto" lets participant count decide.
+func setTopology(client *Client, topology string) {
+	if topology != topologyMesh && topology != topologySFU && topology != topologyAuto {
+		return
+	}
+
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.HostID != client.ID {
+		return
+	}
+
+	if topology == topologyAuto {
+		room.AutoTopology = true
+		evaluateTopology(room.ID)
+		broadcastRoomState(room.ID)
+		return
+	}
+
+	if topology == topologyMesh && room.recording != nil {
+		sendError(client, "Stop the recording before leaving SFU topology")
+		return
+	}
+	if topology == topologyMesh && room.livestream != nil {
+		sendError(client, "Stop the livestream before leaving SFU topology")
+		return
+	}
+	if topology != topologySFU && room.StageMode {
+		sendError(client, "Stage mode requires SFU topology")
+		return
+	}
+
+	room.AutoTopology = false
+	if len(room.Clients) <= 1 && room.migration == nil {
+		// Nobody to coordinate with; switch in place.
+		applyTopology(room, room.ID, topology)
+		broadcastRoomState(room.ID)
+		return
+	}
+	beginMigration(room.ID, topology)
+}
+
+// evaluateTopology starts a migration when an auto room crosses a
+// threshold. Caller must hold the room's lock.
+func evaluateTopology(roomID string) {
+	room, exists := rooms.get(roomID)
+	if !exists || !room.AutoTopology || room.migration != nil {
+		return
+	}
+
+	count := len(room.Clients)
+	switch {
+	case room.Topology == topologyMesh && count >= sfuSwitchUp():
+		beginMigration(roomID, topologySFU)
+	case room.Topology == topologySFU && count <= sfuSwitchDown() && count > 0 && room.recording == nil && room.livestream == nil:
+		beginMigration(roomID, topologyMesh)
+	}
+}
+
+// beginMigration announces the switch and arms the commit timeout. Caller
+// must hold the room's lock.
+func beginMigration(roomID, target string) {
+	room, exists := rooms.get(roomID)
+	if !exists || room.Topology == target {
+		return
+	}
+	if room.migration != nil {
+		if room.migration.target == target {
+			return
+		}
+		// Reversing mid-switch: the old path is still up, so just abandon it.
+		abortMigration(room, roomID)
+	}
+
+	m := &topologyMigration{
+		id:     uuid.New().String(),
+		target: target,
+		ready:  make(map[string]bool),
+	}
+	m.timer = time.AfterFunc(topologySwitchTimeout(), func() {
+		r, ok := lockRoom(roomID)
+		if !ok {
+			return
+		}
+		defer r.mu.Unlock()
+		if r.migration == m {
+			log.Printf("⏱️ Topology switch %s in room %s timed out, committing", m.id, roomID)
+			commitMigration(roomID)
+		}
+	})
+	room.migration = m
+
+	log.Printf("🔀 Room %s migrating %s → %s (%d participants)", roomID, room.Topology, target, len(room.Clients))
+	broadcastMessage(roomID, &outTopologySwitch{
+		messageType: typed("topology-switch"),
+		SwitchID:    m.id,
+		From:        room.Topology,
+		To:          target,
+	})
+}
+
+// markTopologyReady records that a client has the new media path up.
+func markTopologyReady(client *Client, switchID string) {
+	room, exists := lockClientRoom(client)
+	if !exists {
+		return
+	}
+	defer room.mu.Unlock()
+
+	if room.migration == nil || room.migration.id != switchID {
+		return
+	}
+
+	room.migration.ready[client.ID] = true
+	checkMigrationComplete(room.ID)
+}
+
+// checkMigrationComplete commits once every present client is ready. Caller
+// must hold the room's lock.
+func checkMigrationComplete(roomID string) {
+	room, exists := rooms.get(roomID)
+	if !exists || room.migration == nil {
+		return
+	}
+	for id := range room.Clients {
+		if !room.migration.ready[id] {
+			return
+		}
+	}
+	commitMigration(roomID)
+}
+
+// commitMigration makes the target topology authoritative and tells clients
+// to drop the old path. Caller must hold the room's lock.
+func commitMigration(roomID string) {
+	room, exists := rooms.get(roomID)
+	if !exists || room.migration == nil {
+		return
+	}
+
+	m := room.migration
+	m.timer.Stop()
+	room.migration = nil
+	applyTopology(room, roomID, m.target)
+
+	log.Printf("🔀 Room %s committed %s topology", roomID, m.target)
+	broadcastMessage(roomID, &outTopologyCommit{messageType: typed("topology-commit"), SwitchID: m.id, Topology: m.target})
+	broadcastRoomState(roomID)
+
+	// Membership may have moved past the other threshold meanwhile.
+	evaluateTopology(roomID)
+}
+
+// abortMigration cancels a pending switch; clients fall back to the path
+// they never tore down. Caller must hold the room's lock.
+func abortMigration(room *Room, roomID string) {
+	m := room.migration
+	m.timer.Stop()
+	room.migration = nil
+
+	if m.target == topologySFU && room.sfu != nil {
+		go room.sfu.close()
+		room.sfu = nil
+	}
+
+	broadcastMessage(roomID, &outTopologyAbort{messageType: typed("topology-abort"), SwitchID: m.id})
+}
+
+// applyTopology flips the room and releases the SFU when leaving it. Caller
+// must hold the room's lock.
+func applyTopology(room *Room, roomID, topology string) {
+	recordEvent(room, evTopology, "", map[string]interface{}{"topology": topology})
+	if topology == topologyMesh && room.sfu != nil {
+		go room.sfu.close()
+		room.sfu = nil
+	}
+	log.Printf("🔀 Room %s now uses %s topology", roomID, topology)
+}
+
+func topologyState(room *Room) topologyView {
+	mode := "manual"
+	if room.AutoTopology {
+		mode = topologyAuto
+	}
+
+	state := topologyView{Mode: mode, SwitchUp: sfuSwitchUp(), SwitchDown: sfuSwitchDown()}
+	if room.migration != nil {
+		state.Migration = &migrationView{SwitchID: room.migration.id, To: room.migration.target}
+	}
+	return state
+}
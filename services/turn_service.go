@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pion/turn/v4"
+)
+
+// TURNCredentials are time-limited credentials in the TURN REST API format:
+// the username is "<expiry>:<user>" and the password is the base64
+// HMAC-SHA1 of the username keyed with TURN_SECRET.
+type TURNCredentials struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"`
+	URIs     []string `json:"uris"`
+}
+
+func turnPort() int { return envInt("TURN_PORT", 3478) }
+
+func turnRealm() string {
+	if realm := os.Getenv("TURN_REALM"); realm != "" {
+		return realm
+	}
+	return "agoranet"
+}
+
+// StartTURNServer runs the embedded TURN/STUN server on TURN_PORT over UDP
+// and TCP. TURN_PUBLIC_IP is the address handed out for relays, and
+// TURN_RELAY_PORT_MIN/MAX optionally pin relays to a firewall range.
+func StartTURNServer() (*turn.Server, error) {
+	secret := os.Getenv("TURN_SECRET")
+	publicIP := net.ParseIP(os.Getenv("TURN_PUBLIC_IP"))
+	if secret == "" || publicIP == nil {
+		return nil, fmt.Errorf("TURN_SECRET and TURN_PUBLIC_IP must be set")
+	}
+
+	addr := "0.0.0.0:" + strconv.Itoa(turnPort())
+	udpConn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+	}
+	tcpListener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, fmt.Errorf("failed to listen on tcp %s: %w", addr, err)
+	}
+
+	var relay turn.RelayAddressGenerator = &turn.RelayAddressGeneratorStatic{
+		RelayAddress: publicIP,
+		Address:      "0.0.0.0",
+	}
+	minPort, maxPort := envInt("TURN_RELAY_PORT_MIN", 0), envInt("TURN_RELAY_PORT_MAX", 0)
+	if minPort > 0 && maxPort >= minPort {
+		relay = &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: publicIP,
+			Address:      "0.0.0.0",
+			MinPort:      uint16(minPort),
+			MaxPort:      uint16(maxPort),
+		}
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:             turnRealm(),
+		AuthHandler:       turn.LongTermTURNRESTAuthHandler(secret, nil),
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udpConn, RelayAddressGenerator: relay, PermissionHandler: turnPeerAllowed}},
+		ListenerConfigs:   []turn.ListenerConfig{{Listener: tcpListener, RelayAddressGenerator: relay, PermissionHandler: turnPeerAllowed}},
+	})
+	if err != nil {
+		_ = udpConn.Close()
+		_ = tcpListener.Close()
+		return nil, err
+	}
+
+	log.Printf("🧭 TURN server listening on %s (realm %s, relay %s)", addr, turnRealm(), publicIP)
+	return server, nil
+}
+
+// sharedAddressSpace is RFC 6598 carrier-grade NAT space, where some
+// clouds put their metadata service.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// turnPeerAllowed keeps the relay pointed at the internet: any signed-in
+// user can get credentials, so peers on the server's own network are
+// refused. That covers loopback, RFC 1918 and unique local addresses,
+// link-local ones (169.254.169.254 is the usual metadata service) and
+// shared address space.
+func turnPeerAllowed(clientAddr net.Addr, peerIP net.IP) bool {
+	if peerIP.IsLoopback() || peerIP.IsPrivate() || peerIP.IsUnspecified() ||
+		peerIP.IsLinkLocalUnicast() || peerIP.IsLinkLocalMulticast() ||
+		peerIP.IsInterfaceLocalMulticast() || peerIP.IsMulticast() ||
+		sharedAddressSpace.Contains(peerIP) {
+		log.Printf("⛔ TURN refused %s a relay to %s", clientAddr, peerIP)
+		return false
+	}
+	return true
+}
+
+// turnURIs lists the addresses clients should use; TURN_URLS overrides the
+// defaults derived from TURN_PUBLIC_IP and TURN_PORT.
+func turnURIs() []string {
+	host := net.JoinHostPort(os.Getenv("TURN_PUBLIC_IP"), strconv.Itoa(turnPort()))
+	return envList("TURN_URLS", []string{
+		"stun:" + host,
+		"turn:" + host + "?transport=udp",
+		"turn:" + host + "?transport=tcp",
+	})
+}
+
+// IssueTURNCredentials returns credentials for user that expire after
+// TURN_TTL seconds (default one hour).
+func IssueTURNCredentials(user string) (*TURNCredentials, error) {
+	secret := os.Getenv("TURN_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("TURN_SECRET is not set")
+	}
+
+	ttl := envSeconds("TURN_TTL", time.Hour)
+	username, password, err := turn.GenerateLongTermTURNRESTCredentials(secret, user, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TURNCredentials{
+		Username: username,
+		Password: password,
+		TTL:      int(ttl.Seconds()),
+		URIs:     turnURIs(),
+	}, nil
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTURNPeerAllowed(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40000}
+	cases := map[string]bool{
+		"8.8.8.8":         true,
+		"198.51.100.20":   true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00:ec2::254":   false,
+		"100.100.100.200": false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+		"::ffff:10.0.0.1": false,
+	}
+	for ip, want := range cases {
+		if got := turnPeerAllowed(client, net.ParseIP(ip)); got != want {
+			t.Errorf("turnPeerAllowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}